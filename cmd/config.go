@@ -0,0 +1,283 @@
+/*
+Copyright © 2025 Leetsecure hello@leetsecure.com
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+)
+
+var ConfigShowOrigin bool
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and change settings",
+	Long: `Settings are resolved per profile, from the highest precedence down, from:
+  policy   the policy section of ` + config.SystemConfigPath + `, which cannot be overridden
+  flag     a command line flag
+  user     the per-user config file, changed with config set, unset and edit
+  env      a QRYPTIC_* environment variable
+  system   the top-level settings of ` + config.SystemConfigPath + `
+  default  the built-in default`,
+}
+
+var configListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"show"},
+	Short:   "List the effective settings of the profile",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("Profile: %s\n", storage.Profile())
+		policy := storage.Policy()
+		for _, setting := range config.Settings {
+			value, origin := storage.Setting(setting.Key)
+			locked := ""
+			if policy.Locked(setting.Key) {
+				locked = " (locked)"
+			}
+			if ConfigShowOrigin {
+				fmt.Printf("%-22s %-40s %s\n", setting.Key, formatSettingValue(value), origin)
+			} else {
+				fmt.Printf("%-22s %s%s\n", setting.Key, formatSettingValue(value), locked)
+			}
+		}
+		if !ConfigShowOrigin {
+			return
+		}
+		loginMethods := []string{}
+		for _, method := range []string{config.LoginMethodSSO, config.LoginMethodPassword, config.LoginMethodDevice, config.LoginMethodToken, config.LoginMethodMachine} {
+			if policy.LoginMethodAllowed(method) {
+				loginMethods = append(loginMethods, method)
+			}
+		}
+		fmt.Printf("\nAllowed login methods: %s\n", strings.Join(loginMethods, ", "))
+		if !policy.RouteOverridesAllowed() {
+			fmt.Println("Route overrides: disabled by policy, the system-wide split domains and excluded routes apply")
+		}
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the effective value of a setting",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if isSet, ok := credentialIsSet(args[0]); ok {
+			if isSet {
+				fmt.Println("<redacted>")
+			} else {
+				fmt.Println("<not set>")
+			}
+			return
+		}
+		setting := lookupSettingOrExit(args[0])
+		value, origin := storage.Setting(setting.Key)
+		if ConfigShowOrigin {
+			fmt.Printf("%s\t%s\n", formatSettingValue(value), origin)
+			return
+		}
+		fmt.Println(formatSettingValue(value))
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change a setting of the profile",
+	Long:  `Change a setting in the per-user config. Lists are comma separated, durations are written like 30s or 5m`,
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		refuseCredential(args[0])
+		setting := lookupSettingOrExit(args[0])
+		value, err := setting.Parse(args[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := storage.SetSetting(setting.Key, value); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		warnIfShadowed(setting)
+	},
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Remove a setting from the profile, restoring the system-wide or built-in default",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		refuseCredential(args[0])
+		setting := lookupSettingOrExit(args[0])
+		if err := storage.UnsetSetting(setting.Key); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit the per-user config file in $EDITOR",
+	Long:  `Open the per-user config file in $VISUAL or $EDITOR. The edited file is validated before it replaces the current one, credentials are not part of it`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := editConfig(storage.ConfigFile()); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// editConfig edits a copy of path and replaces path with it once it is valid,
+// unless another process changed path in the meantime.
+func editConfig(path string) error {
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".qryptic-edit-*.yaml")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	_, err = tmp.Write(current)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	var edited []byte
+	for {
+		if err := runEditor(tmpPath); err != nil {
+			return err
+		}
+		edited, err = os.ReadFile(tmpPath)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(edited, current) {
+			fmt.Println("No changes")
+			return nil
+		}
+		err = config.ValidateConfig(current, edited, storage.Policy())
+		if err == nil {
+			break
+		}
+		fmt.Println(err)
+		prompt := promptui.Prompt{Label: "Edit again", IsConfirm: true}
+		if _, err := prompt.Run(); err != nil {
+			return errors.New("changes discarded")
+		}
+	}
+
+	unlock, err := storage.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	latest, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(latest, current) {
+		return errors.New("the config was changed by another qryptic command while editing, run config edit again")
+	}
+	if err := config.WriteFileAtomic(path, edited, 0600); err != nil {
+		return err
+	}
+	fmt.Println("Config updated")
+	return nil
+}
+
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	parts := strings.Fields(editor)
+	editorCmd := exec.Command(parts[0], append(parts[1:], path)...)
+	editorCmd.Stdin, editorCmd.Stdout, editorCmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := editorCmd.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+	return nil
+}
+
+func lookupSettingOrExit(key string) config.Setting {
+	setting, ok := config.LookupSetting(key)
+	if !ok {
+		keys := []string{}
+		for _, setting := range config.Settings {
+			keys = append(keys, setting.Key)
+		}
+		fmt.Printf("Unknown setting %s, use one of: %s\n", key, strings.Join(keys, ", "))
+		os.Exit(1)
+	}
+	return setting
+}
+
+// credentialIsSet reports whether the credential named key is stored, ok is
+// false when key is not a credential. Credentials are never printed.
+func credentialIsSet(key string) (isSet bool, ok bool) {
+	switch {
+	case strings.EqualFold(key, config.AuthToken):
+		_, isSet = storage.GetAuthToken()
+	case strings.EqualFold(key, config.RefreshToken):
+		_, isSet = storage.GetRefreshToken()
+	case strings.EqualFold(key, config.MachineClientSecret):
+		identity, _, _ := storage.GetMachineIdentity()
+		isSet = identity.ClientSecret != ""
+	default:
+		return false, false
+	}
+	return isSet, true
+}
+
+func refuseCredential(key string) {
+	if _, ok := credentialIsSet(key); ok {
+		fmt.Printf("%s is a credential, use qryptic login or logout to change it\n", key)
+		os.Exit(1)
+	}
+}
+
+// warnIfShadowed tells the user when the value just set is not the one used.
+func warnIfShadowed(setting config.Setting) {
+	if _, origin := storage.Setting(setting.Key); origin.Layer != config.OriginUser {
+		fmt.Printf("Saved, but %s is currently taken from %s\n", setting.Key, origin)
+	}
+}
+
+// formatSettingValue prints lists the way they are given on the command line.
+func formatSettingValue(value interface{}) string {
+	if values, ok := value.([]string); ok {
+		return strings.Join(values, ",")
+	}
+	return cast.ToString(value)
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configListCmd, configGetCmd, configSetCmd, configUnsetCmd, configEditCmd)
+	configListCmd.Flags().BoolVar(&ConfigShowOrigin, "origin", false, "Also show where each value comes from")
+	configGetCmd.Flags().BoolVar(&ConfigShowOrigin, "origin", false, "Also show where the value comes from")
+}
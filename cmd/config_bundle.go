@@ -0,0 +1,192 @@
+/*
+Copyright © 2025 Leetsecure hello@leetsecure.com
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/auth"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+var BundlePassphraseStdin bool
+var ImportOverwrite bool
+
+var configExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Export profiles and settings to an encrypted bundle",
+	Long: `Write every profile with its settings, route overrides, API tokens and machine
+identities to a passphrase protected file, to be imported on another machine with
+config import. Session tokens and WireGuard keys are not exported, profiles log in
+and gateways are registered again on import`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		passphrase, err := bundlePassphrase(true)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		bundle, err := config.ExportBundle(storage)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		data, err := config.SealBundle(bundle, passphrase)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := config.WriteFileAtomic(args[0], data, 0600); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Exported %d profile(s) to %s\n", len(bundle.Profiles), args[0])
+	},
+}
+
+var configImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a bundle written by config export",
+	Long: `Restore the profiles of a bundle written by config export and register this
+machine with their controllers again. Profiles that exist here and are logged in
+are kept unless --overwrite is given`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		passphrase, err := bundlePassphrase(false)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		bundle, err := config.OpenBundle(data, passphrase)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		results, err := config.ImportBundle(storage, bundle, ImportOverwrite)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		gateways := map[string][]string{}
+		for _, profile := range bundle.Profiles {
+			gateways[profile.Name] = profile.Gateways
+		}
+		for _, result := range results {
+			if result.Skipped {
+				fmt.Printf("%s: SKIP already logged in here, use --overwrite to replace it\n", result.Profile)
+				continue
+			}
+			for _, warning := range result.Warnings {
+				fmt.Printf("%s: WARN %s\n", result.Profile, warning)
+			}
+			fmt.Printf("%s: %s\n", result.Profile, registerImportedProfile(storage.ForProfile(result.Profile), gateways[result.Profile]))
+		}
+	},
+}
+
+// registerImportedProfile renews the imported credentials for this machine
+// and requests new clients for the gateways used on the old one.
+func registerImportedProfile(profile config.Store, gateways []string) string {
+	baseUrl, exists := profile.GetBaseUrl()
+	if !exists {
+		return "imported, no controller url set"
+	}
+	if identity, _, isMachine := profile.GetMachineIdentity(); isMachine {
+		// The device id belongs to the old machine
+		deviceId, err := machineDeviceId()
+		if err != nil {
+			return "imported, could not determine the device identity of this machine: " + err.Error()
+		}
+		hostname, _ := os.Hostname()
+		authResponse, err := auth.RequestMachineToken(baseUrl, identity, deviceId, hostname)
+		if err != nil {
+			return "imported, machine login failed: " + err.Error()
+		}
+		if err := errors.Join(
+			profile.SetMachineIdentity(identity, deviceId),
+			profile.SetMachineToken(authResponse.AuthToken, authResponse.AuthTokenExpiry(time.Now())),
+			profile.SetAuthForUrl(baseUrl),
+		); err != nil {
+			return "imported, failed to save the machine login: " + err.Error()
+		}
+	}
+	if _, loggedIn := profile.GetAuthToken(); !loggedIn {
+		return fmt.Sprintf("imported, log in with: qryptic --profile %s login", profile.Profile())
+	}
+	if !auth.EnsureAuthTokenValid(profile) {
+		return fmt.Sprintf("imported, credentials expired, log in again with: qryptic --profile %s login", profile.Profile())
+	}
+
+	registered := 0
+	qrypticClient := auth.NewAuthenticatedClient(profile)
+	for _, uuid := range gateways {
+		statusCode, clientConfig, err := qrypticClient.GetGatewayClient(uuid)
+		if err != nil || statusCode != http.StatusOK {
+			continue
+		}
+		if err := profile.SetQrypticClient(uuid, *clientConfig); err != nil {
+			return fmt.Sprintf("imported, logged in, failed to save gateway client %s: %s", uuid, err)
+		}
+		registered++
+	}
+	return fmt.Sprintf("imported, logged in, %d of %d gateway(s) registered", registered, len(gateways))
+}
+
+// bundlePassphrase reads the bundle passphrase from stdin, the environment
+// or a prompt, asking twice when exporting.
+func bundlePassphrase(confirm bool) (string, error) {
+	passphrase := os.Getenv(config.EnvBundlePassphrase)
+	if BundlePassphraseStdin {
+		line, err := stdin.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to read passphrase from stdin: %w", err)
+		}
+		passphrase = strings.TrimRight(line, "\r\n")
+	}
+	if passphrase == "" {
+		requirePrompt("A passphrase", fmt.Sprintf("Use --passphrase-stdin or %s", config.EnvBundlePassphrase))
+		prompt := promptui.Prompt{Label: "Bundle passphrase", Mask: '*'}
+		var err error
+		passphrase, err = prompt.Run()
+		if err != nil {
+			return "", err
+		}
+		if confirm {
+			again := promptui.Prompt{Label: "Repeat passphrase", Mask: '*'}
+			repeated, err := again.Run()
+			if err != nil {
+				return "", err
+			}
+			if repeated != passphrase {
+				return "", fmt.Errorf("passphrases do not match")
+			}
+		}
+	}
+	if confirm && len(passphrase) < config.MinBundlePassphraseLength {
+		return "", fmt.Errorf("the passphrase must be at least %d characters", config.MinBundlePassphraseLength)
+	}
+	return passphrase, nil
+}
+
+func init() {
+	configCmd.AddCommand(configExportCmd, configImportCmd)
+	for _, cmd := range []*cobra.Command{configExportCmd, configImportCmd} {
+		cmd.Flags().BoolVar(&BundlePassphraseStdin, "passphrase-stdin", false, "Read the passphrase from stdin (env "+config.EnvBundlePassphrase+")")
+		cmd.Flags().BoolVar(&NoPrompt, "no-prompt", false, "Never prompt, fail if the passphrase is missing (env "+config.EnvNoPrompt+")")
+	}
+	configImportCmd.Flags().BoolVar(&ImportOverwrite, "overwrite", false, "Replace profiles that are already logged in on this machine")
+}
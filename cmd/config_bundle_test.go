@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+const testBundlePassphrase = "correct horse battery"
+
+// setBundleInput points the command state at store and stdin, and resets the
+// bundle flags, for the duration of the test.
+func setBundleInput(t *testing.T, store config.Store, input string) {
+	t.Helper()
+	savedStorage, savedStdin := storage, stdin
+	t.Cleanup(func() {
+		storage, stdin = savedStorage, savedStdin
+		BundlePassphraseStdin, ImportOverwrite, NoPrompt = false, false, false
+	})
+	storage, stdin = store, bufio.NewReader(strings.NewReader(input))
+	BundlePassphraseStdin, ImportOverwrite, NoPrompt = false, false, false
+	t.Setenv(config.EnvBundlePassphrase, "")
+	t.Setenv(config.EnvNoPrompt, "")
+}
+
+// captureStdout returns what run printed.
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		output <- string(data)
+	}()
+	defer func() { os.Stdout = saved }()
+	run()
+	writer.Close()
+	return <-output
+}
+
+// newGatewayServer serves gateway clients for any UUID and records the
+// requested paths.
+func newGatewayServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	paths := &[]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*paths = append(*paths, r.URL.Path)
+		json.NewEncoder(w).Encode(models.WGClientConfig{
+			ClientUuid:              "client-new",
+			WGClientInterfaceConfig: models.WGClientInterfaceConfig{ClientPrivateKey: "new-private-key"},
+			ExpiryTime:              time.Now().Add(time.Hour),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, paths
+}
+
+// exportTestBundle exports a store with an unconfigured default profile and a
+// work profile on an API token with one gateway, and returns the file.
+func exportTestBundle(t *testing.T, baseUrl string) string {
+	t.Helper()
+	source := config.NewMemoryStore()
+	must(t, source.AddProfile("work", baseUrl))
+	work := source.ForProfile("work")
+	must(t, work.SetAPIToken("api-token"))
+	must(t, work.SetAuthForUrl(baseUrl))
+	must(t, work.SetQrypticClient("GW-1", models.WGClientConfig{ClientUuid: "client-old"}))
+
+	setBundleInput(t, source, "")
+	t.Setenv(config.EnvBundlePassphrase, testBundlePassphrase)
+	NoPrompt = true
+	path := filepath.Join(t.TempDir(), "qryptic.bundle")
+	output := captureStdout(t, func() { configExportCmd.Run(configExportCmd, []string{path}) })
+	if !strings.Contains(output, "Exported 2 profile(s)") {
+		t.Fatalf("export output:\n%s", output)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("bundle mode %#o", info.Mode().Perm())
+	}
+	return path
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigImportPassphraseFromEnv(t *testing.T) {
+	server, paths := newGatewayServer(t)
+	path := exportTestBundle(t, server.URL)
+
+	target := config.NewMemoryStore()
+	setBundleInput(t, target, "")
+	t.Setenv(config.EnvBundlePassphrase, testBundlePassphrase)
+	NoPrompt = true
+	output := captureStdout(t, func() { configImportCmd.Run(configImportCmd, []string{path}) })
+
+	for _, want := range []string{
+		"default: imported, no controller url set",
+		"work: imported, logged in, 1 of 1 gateway(s) registered",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("import output lacks %q:\n%s", want, output)
+		}
+	}
+	if len(*paths) != 1 || (*paths)[0] != "/api/v1/gateway/GW-1/client" {
+		t.Errorf("requested %v", *paths)
+	}
+	work := target.ForProfile("work")
+	if token, _ := work.GetAuthToken(); token != "api-token" {
+		t.Errorf("work token %q", token)
+	}
+	if clientConfig, _ := work.GetQrypticClient("GW-1"); clientConfig.ClientUuid != "client-new" {
+		t.Errorf("gateway client %+v, want the one registered for this machine", clientConfig)
+	}
+}
+
+func TestConfigImportPassphraseFromStdin(t *testing.T) {
+	server, _ := newGatewayServer(t)
+	path := exportTestBundle(t, server.URL)
+
+	target := config.NewMemoryStore()
+	setBundleInput(t, target, testBundlePassphrase+"\n")
+	// stdin wins over the environment
+	t.Setenv(config.EnvBundlePassphrase, "not the passphrase")
+	BundlePassphraseStdin, NoPrompt = true, true
+	output := captureStdout(t, func() { configImportCmd.Run(configImportCmd, []string{path}) })
+	if !strings.Contains(output, "work: imported, logged in") {
+		t.Errorf("import output:\n%s", output)
+	}
+}
+
+func TestConfigImportExistingProfiles(t *testing.T) {
+	server, _ := newGatewayServer(t)
+	path := exportTestBundle(t, server.URL)
+
+	for _, overwrite := range []bool{false, true} {
+		target := config.NewMemoryStore()
+		must(t, target.AddProfile("work", server.URL))
+		must(t, target.ForProfile("work").SetAuthTokens("session-token", "session-refresh", time.Time{}))
+
+		setBundleInput(t, target, "")
+		t.Setenv(config.EnvBundlePassphrase, testBundlePassphrase)
+		ImportOverwrite, NoPrompt = overwrite, true
+		output := captureStdout(t, func() { configImportCmd.Run(configImportCmd, []string{path}) })
+
+		token, _ := target.ForProfile("work").GetAuthToken()
+		if overwrite {
+			if strings.Contains(output, "SKIP") || token != "api-token" {
+				t.Errorf("--overwrite: token %q, output:\n%s", token, output)
+			}
+			continue
+		}
+		if !strings.Contains(output, "work: SKIP already logged in here, use --overwrite to replace it") {
+			t.Errorf("import output:\n%s", output)
+		}
+		if token != "session-token" {
+			t.Errorf("logged in profile replaced, token %q", token)
+		}
+		if !strings.Contains(output, "default: imported") {
+			t.Errorf("other profiles not imported:\n%s", output)
+		}
+	}
+}
+
+func TestBundlePassphrase(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		stdin   string
+		fromStd bool
+		confirm bool
+		want    string
+		wantErr bool
+	}{
+		{name: "env", env: testBundlePassphrase, want: testBundlePassphrase},
+		{name: "stdin", stdin: testBundlePassphrase + "\r\n", fromStd: true, want: testBundlePassphrase},
+		{name: "stdin without newline", stdin: testBundlePassphrase, fromStd: true, want: testBundlePassphrase},
+		{name: "short on export", env: "short", confirm: true, wantErr: true},
+		{name: "short on import", env: "short", want: "short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBundleInput(t, config.NewMemoryStore(), tt.stdin)
+			t.Setenv(config.EnvBundlePassphrase, tt.env)
+			BundlePassphraseStdin, NoPrompt = tt.fromStd, true
+			got, err := bundlePassphrase(tt.confirm)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("passphrase %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("passphrase %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBundlePassphraseNoPrompt(t *testing.T) {
+	if os.Getenv("QRYPTIC_TEST_BUNDLE_NO_PROMPT") == "1" {
+		setBundleInput(t, config.NewMemoryStore(), "")
+		NoPrompt = true
+		bundlePassphrase(false)
+		return
+	}
+	// requirePrompt exits, so the missing passphrase is tried in a child process.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestBundlePassphraseNoPrompt$")
+	cmd.Env = append(os.Environ(), "QRYPTIC_TEST_BUNDLE_NO_PROMPT=1")
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("exit %v, want status 1:\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "--passphrase-stdin or "+config.EnvBundlePassphrase) {
+		t.Errorf("output does not say how to pass the passphrase:\n%s", output)
+	}
+}
@@ -4,26 +4,41 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/leetsecure/qryptic-client-cli/internal/auth"
-	"github.com/leetsecure/qryptic-client-cli/internal/client"
 	"github.com/leetsecure/qryptic-client-cli/internal/config"
 	"github.com/leetsecure/qryptic-client-cli/internal/logger"
 	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/leetsecure/qryptic-client-cli/internal/platform"
+	"github.com/leetsecure/qryptic-client-cli/internal/splitdns"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
 )
 
+var SplitDomains []string
+var DNSListenAddr string
+var KillSwitch bool
+var AllowLAN bool
+
 // connectCmd represents the connect command
 var connectCmd = &cobra.Command{
 	Use:   "connect",
 	Short: "Connect to Qryptic gateway",
 	Long:  `Connect to any of the accessible Qryptic gateway`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("split-domain") && !storage.Policy().RouteOverridesAllowed() {
+			logger.Default().Error("Split domains are managed by your administrator and cannot be overridden", "policy", config.SystemConfigPath)
+			os.Exit(1)
+		}
 		listAccessibleGateways()
 	},
 }
@@ -37,16 +52,15 @@ func listAccessibleGateways() {
 		log.Error("Please login first ... ")
 		return
 	}
-	if !auth.IsBaseUrlHealthy() {
+	if !auth.IsBaseUrlHealthy(baseUrl) {
 		log.Error("Check if the Qryptic service is running at the", "set URL", baseUrl)
 		return
 	}
-	if !auth.IsAuthTokenValid() {
+	if !auth.EnsureAuthTokenValid(storage) {
 		log.Error("Please authenticate ...")
 		return
 	}
-	authToken, _ := storage.GetAuthToken()
-	qrypticClient := client.NewQrypticClient(baseUrl, authToken)
+	qrypticClient := auth.NewAuthenticatedClient(storage)
 	statusCode, resp, err := qrypticClient.ListAccessibleGateways()
 	if err != nil {
 		log.Error(err.Error())
@@ -92,9 +106,7 @@ func getGatewayClient(uuid string) (models.WGClientConfig, error) {
 	if ifClientExisting {
 		return oldQrypticClient, nil
 	}
-	baseUrl, _ := storage.GetBaseUrl()
-	authToken, _ := storage.GetAuthToken()
-	qrypticClient := client.NewQrypticClient(baseUrl, authToken)
+	qrypticClient := auth.NewAuthenticatedClient(storage)
 	statusCode, clientConfig, err := qrypticClient.GetGatewayClient(uuid)
 	if err != nil {
 		log.Error(err.Error())
@@ -119,17 +131,100 @@ func connectToGateway(uuid, name string) {
 	if err != nil {
 		return
 	}
+	killSwitch, allowLAN := storage.GetKillSwitch()
+	// A kill switch left by an earlier connection keeps blocking when this one fails.
+	killSwitchWasEnabled := wg.IsKillSwitchEnabled()
+	if killSwitch {
+		// Installed before the tunnel comes up so that nothing leaks while switching
+		baseUrl, _ := storage.GetBaseUrl()
+		if err := wg.EnableKillSwitch(clientConfig, allowLAN, baseUrl); err != nil {
+			log.Error(err.Error())
+			return
+		}
+	}
+	splitDomains := storage.GetSplitDNSDomains()
+	wg.SplitDNS = len(splitDomains) > 0
+	wg.ExcludedRoutes = config.StringListSetting(storage, config.RoutesExclude)
 	err = wg.ApplyConfig(clientConfig)
 	if err != nil {
 		log.Error(err.Error())
+		if killSwitch && !killSwitchWasEnabled {
+			if err := wg.DisableKillSwitch(); err != nil {
+				log.Error(err.Error())
+			}
+		}
+		fmt.Println("Connection failed !!")
+		os.Exit(1)
 	}
 	storage.SetConnectedToGateway(uuid, name)
 	uuid, name, exists := storage.GetConnectedToGateway()
 	if exists {
 		wgclientConfig, _ := storage.GetQrypticClient(uuid)
-		fmt.Printf("Connected to %s gateway at %s:%d\n", name, wgclientConfig.WGClientPeerConfig.VpnGatewayIP, wgclientConfig.WGClientPeerConfig.VpnGatewayPort)
+		fmt.Printf("Connected to %s gateway at %s\n", name, wgclientConfig.WGClientPeerConfig.Endpoint())
 	} else {
 		fmt.Println("Connection failed !!")
+		return
+	}
+	if wg.SplitDNS {
+		runSplitDNS(clientConfig, splitDomains)
+	}
+}
+
+// runSplitDNS serves the local DNS stub in the foreground until interrupted.
+// Only the addresses resolved for the split domains are routed through the tunnel.
+func runSplitDNS(clientConfig models.WGClientConfig, domains []string) {
+	log := logger.Default()
+	tunnelDNS := strings.TrimSpace(strings.Split(clientConfig.WGClientInterfaceConfig.DnsServer, ",")[0])
+	tunnelDNSIP := net.ParseIP(tunnelDNS)
+	if tunnelDNSIP == nil {
+		log.Error("Gateway did not provide a usable DNS server for split DNS", "dnsServer", clientConfig.WGClientInterfaceConfig.DnsServer)
+		return
+	}
+	// Undo a session that did not shut down cleanly, so that the upstream
+	// recorded below is the real resolver and not the stub
+	if err := wg.DNS.Restore(); err != nil {
+		log.Error(err.Error())
+		return
+	}
+	systemDNS, err := platform.GetSystemNameserver()
+	if err != nil {
+		log.Error("Could not determine the system DNS server", "error", err.Error())
+		return
+	}
+	listenAddr := storage.GetSplitDNSListenAddr()
+	if listenHost, _, err := net.SplitHostPort(listenAddr); err == nil && listenHost == systemDNS {
+		log.Error("The system resolver points at the split DNS stub address. Set the upstream resolver in /etc/resolv.conf first", "listen", listenAddr)
+		return
+	}
+
+	routes := splitdns.NewRouteManager(wg.TunnelInterface(), config.SplitDNSMinRouteTTL)
+	defer routes.Flush()
+	if err := routes.Pin(tunnelDNSIP); err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	stub := splitdns.NewStub(listenAddr, tunnelDNS, systemDNS, domains, routes)
+	if err := stub.Listen(); err != nil {
+		log.Error(err.Error())
+		return
+	}
+	// Only now that the stub answers can the resolver be pointed at it
+	if err := wg.DNS.ApplyStub(wg.TunnelInterface(), stub.Addr(), stub.Domains); err != nil {
+		log.Error(err.Error())
+		return
+	}
+	defer func() {
+		if err := wg.DNS.Restore(); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Split DNS active for %s, listening on %s. Press Ctrl+C to stop.\n", strings.Join(stub.Domains, ", "), stub.Addr())
+	if err := stub.Run(ctx); err != nil {
+		log.Error(err.Error())
 	}
 }
 
@@ -154,4 +249,12 @@ func promptGatewaySelect(pc models.PromptContent, gateways []models.GatewayRespo
 
 func init() {
 	rootCmd.AddCommand(connectCmd)
+	connectCmd.Flags().StringSliceVar(&SplitDomains, "split-domain", nil, "Only route these domains (and their subdomains) through the gateway, resolved via the gateway DNS")
+	bindProfileFlag(config.SplitDNSDomains, connectCmd.Flags().Lookup("split-domain"))
+	connectCmd.Flags().StringVar(&DNSListenAddr, "dns-listen", "127.0.0.1:53", "Listen address of the local DNS stub used for split DNS")
+	bindProfileFlag(config.SplitDNSListenAddr, connectCmd.Flags().Lookup("dns-listen"))
+	connectCmd.Flags().BoolVar(&KillSwitch, "kill-switch", false, "Block all traffic outside the tunnel until an explicit disconnect")
+	bindProfileFlag(config.KillSwitchEnabled, connectCmd.Flags().Lookup("kill-switch"))
+	connectCmd.Flags().BoolVar(&AllowLAN, "allow-lan", false, "Allow local network traffic while the kill switch is active")
+	bindProfileFlag(config.KillSwitchAllowLAN, connectCmd.Flags().Lookup("allow-lan"))
 }
@@ -23,6 +23,9 @@ var disconnectCmd = &cobra.Command{
 			fmt.Printf("Error in stopping the running qryptic client \n %s \n", err.Error())
 
 		}
+		if err := wg.DisableKillSwitch(); err != nil {
+			log.Error(err.Error())
+		}
 		log.Info("Qryptic disconnected")
 	},
 }
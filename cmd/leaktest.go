@@ -0,0 +1,65 @@
+/*
+Copyright © 2025 Leetsecure hello@leetsecure.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/leaktest"
+	"github.com/leetsecure/qryptic-client-cli/internal/wireguard"
+	"github.com/spf13/cobra"
+)
+
+// leaktestCmd represents the leaktest command
+var leaktestCmd = &cobra.Command{
+	Use:   "leaktest",
+	Short: "Check the tunnel for traffic leaks",
+	Long:  `Verify that routes, DNS and IPv6 traffic follow the policy of the connected Qryptic gateway`,
+	Run: func(cmd *cobra.Command, args []string) {
+		uuid, name, exists := storage.GetConnectedToGateway()
+		if !exists {
+			fmt.Println("Qryptic is not running")
+			os.Exit(1)
+		}
+		clientConfig, err := storage.ForProfile(storage.GetConnectedProfile()).GetQrypticClient(uuid)
+		if err != nil {
+			fmt.Println("Error reading the gateway configuration")
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		interfaceName := wg.TunnelInterface()
+		nameservers := leaktest.ResolvConfNameservers
+		if wg.DNS.Backend == wireguard.DNSBackendSystemdResolved {
+			nameservers = func() ([]string, error) {
+				return leaktest.ResolvectlNameservers(interfaceName)
+			}
+		}
+		checker := leaktest.NewChecker(interfaceName, clientConfig, nameservers)
+		if wg.RoutingDisabled() {
+			checker.SplitDNSStub = storage.ForProfile(storage.GetConnectedProfile()).GetSplitDNSListenAddr()
+		}
+
+		fmt.Printf("Leak test for %s gateway on %s\n", name, interfaceName)
+		failed := 0
+		for _, result := range checker.Run() {
+			status := "PASS"
+			if !result.Passed {
+				status = "FAIL"
+				failed++
+			}
+			fmt.Printf("  %s  %-24s %s\n", status, result.Name, result.Detail)
+		}
+		if failed > 0 {
+			fmt.Printf("%d check(s) failed\n", failed)
+			os.Exit(1)
+		}
+		fmt.Println("All checks passed")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(leaktestCmd)
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
@@ -16,15 +17,19 @@ import (
 	"github.com/leetsecure/qryptic-client-cli/internal/config"
 	"github.com/leetsecure/qryptic-client-cli/internal/logger"
 	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/leetsecure/qryptic-client-cli/internal/pkce"
 	"github.com/leetsecure/qryptic-client-cli/internal/platform"
 	"github.com/leetsecure/qryptic-client-cli/internal/utils"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var URL string
 var ForceLogin bool
+var DeviceLogin bool
+var NoBrowser bool
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
 
 var loginCmd = &cobra.Command{
 	Use:   "login",
@@ -35,43 +40,123 @@ var loginCmd = &cobra.Command{
 
 func loginExecute(cmd *cobra.Command, args []string) {
 	log := logger.Default()
-	baseUrl, _ := storage.GetBaseUrl()
+	baseUrl, exists := storage.GetBaseUrl()
+	if !exists {
+		log.Error("No controller url set for this profile, pass it with --url", "profile", storage.Profile())
+		return
+	}
 	isValidUrl := auth.IsURL(baseUrl)
 	if !isValidUrl {
 		log.Error("Please make sure the url is in format : http[s]://<domain/subdomain> \n Example : https://qryptic.leetsecure.com")
 		return
 	}
-	if baseUrl[len(baseUrl)-1:] == "/" {
-		baseUrl = baseUrl[:len(baseUrl)-1]
-		storage.SetBaseUrl(baseUrl)
-	}
-	if !auth.IsBaseUrlHealthy() {
+	if !auth.IsBaseUrlHealthy(baseUrl) {
 		log.Error("Given url is unhealthy. Check again if the url is correct. If URL is correct, check with Admin if the Qryptic service is running", "url", baseUrl)
 		return
 	}
+	// Flags only apply to this run, remember the controller logged in to.
+	if cmd.Flags().Changed("url") {
+		if err := storage.SetBaseUrl(baseUrl); err != nil {
+			log.Error(err.Error())
+			return
+		}
+	}
 
 	authForUrl, _ := storage.GetAuthForUrl()
 	if !ForceLogin && (baseUrl == authForUrl) {
-		isValid := auth.IsAuthTokenValid()
+		isValid := auth.EnsureAuthTokenValid(storage)
 		if isValid {
 			log.Info("Already authenticated")
 			return
 		}
 	}
+	if MachineLogin {
+		if loginMethodAllowed(config.LoginMethodMachine) {
+			loginAsMachine()
+		}
+		return
+	}
+	if token := loginToken(); token != "" {
+		if loginMethodAllowed(config.LoginMethodToken) {
+			loginWithToken(token)
+		}
+		return
+	}
+	if DeviceLogin {
+		if loginMethodAllowed(config.LoginMethodDevice) {
+			loginWithDevice()
+		}
+		return
+	}
+	if loginEmail() != "" || PasswordStdin {
+		if loginMethodAllowed(config.LoginMethodPassword) {
+			loginWithEmailAndPassword()
+		}
+		return
+	}
+	requirePrompt("A login method", fmt.Sprintf("Use --token (or %s), or --email with --password-stdin (or %s and %s)", config.EnvToken, config.EnvEmail, config.EnvPassword))
 	selectLoginMethod()
 }
 
 func init() {
 	rootCmd.AddCommand(loginCmd)
 	loginCmd.Flags().StringVarP(&URL, "url", "u", "", "Custom url for your organisation's qryptic controller")
-	loginCmd.MarkFlagRequired("url")
-	viper.GetViper().BindPFlag(config.BaseUrl, loginCmd.Flags().Lookup("url"))
+	bindProfileFlag(config.BaseUrl, loginCmd.Flags().Lookup("url"))
 	loginCmd.Flags().BoolVarP(&ForceLogin, "force", "f", false, "Force new login to replace the existing auth credentials with new one")
+	loginCmd.Flags().BoolVar(&DeviceLogin, "device", false, "Login from another device using a short code, for SSH sessions and machines without a browser")
+	loginCmd.Flags().StringVar(&TOTPCode, "totp", "", "Authenticator app code, when the account requires a second factor")
+	loginCmd.Flags().StringVar(&RecoveryCode, "recovery-code", "", "Recovery code, when the account requires a second factor")
+	loginCmd.Flags().BoolVar(&EmailCode, "email-code", false, "Use a one-time code sent by email as second factor, read from stdin when not prompting")
+	loginCmd.MarkFlagsMutuallyExclusive("totp", "recovery-code", "email-code")
+	loginCmd.Flags().BoolVar(&NoBrowser, "no-browser", false, "Do not open a browser, print the SSO link and wait for the login to be completed elsewhere")
+	loginCmd.Flags().StringVar(&LoginEmail, "email", "", "Email id for email & password login (env "+config.EnvEmail+")")
+	loginCmd.Flags().BoolVar(&PasswordStdin, "password-stdin", false, "Read the password from stdin (env "+config.EnvPassword+")")
+	loginCmd.Flags().StringVar(&LoginToken, "token", "", "Login with a pre-issued API or personal access token (env "+config.EnvToken+")")
+	loginCmd.Flags().BoolVar(&NoPrompt, "no-prompt", false, "Never prompt, fail if required input is missing (env "+config.EnvNoPrompt+")")
+	loginCmd.Flags().BoolVar(&MachineLogin, "machine", false, "Login as this machine instead of a person, for servers and build agents")
+	loginCmd.Flags().StringVar(&ClientId, "client-id", "", "Client id of the machine identity (env "+config.EnvClientId+")")
+	loginCmd.Flags().BoolVar(&ClientSecretStdin, "client-secret-stdin", false, "Read the machine client secret from stdin (env "+config.EnvClientSecret+")")
+	loginCmd.Flags().StringVar(&PrivateKeyPath, "private-key", "", "PEM private key used to sign the machine's client assertion (env "+config.EnvPrivateKeyFile+")")
+	loginCmd.MarkFlagsMutuallyExclusive("client-secret-stdin", "private-key")
+	loginCmd.MarkFlagsMutuallyExclusive("machine", "token")
+	loginCmd.MarkFlagsMutuallyExclusive("machine", "email")
+	loginCmd.MarkFlagsMutuallyExclusive("machine", "device")
+	loginCmd.MarkFlagsMutuallyExclusive("token", "email")
+	loginCmd.MarkFlagsMutuallyExclusive("token", "device")
+}
+
+const (
+	loginProviderPassword = "password"
+	loginProviderOIDC     = "oidc"
+)
+
+// loginMethodAllowed reports an error when the system policy does not allow method.
+func loginMethodAllowed(method string) bool {
+	if storage.Policy().LoginMethodAllowed(method) {
+		return true
+	}
+	logger.Default().Error("This login method is disabled by your administrator", "method", method, "policy", config.SystemConfigPath)
+	return false
 }
 
-func promptLoginMethodSelect(pc models.PromptContent) (string, int) {
+// defaultLoginProviders are offered by controllers that predate provider discovery.
+var defaultLoginProviders = []models.LoginProvider{
+	{Id: "password", Type: loginProviderPassword, DisplayName: "Email & Password"},
+	{
+		Id:           "google",
+		Type:         loginProviderOIDC,
+		DisplayName:  "Google SSO",
+		InitiatePath: "/api/v1/auth/google/web/sso/initiate",
+		TokenPath:    "/api/v1/auth/google/web/sso/token",
+	},
+}
+
+func promptLoginMethodSelect(pc models.PromptContent, providers []models.LoginProvider) (string, int) {
 	log := logger.Default()
-	items := []string{"Email & Password", "Google SSO"}
+	items := []string{}
+	for _, provider := range providers {
+		items = append(items, provider.DisplayName)
+	}
 	prompt := promptui.Select{
 		Label: pc.Label,
 		Items: items,
@@ -86,36 +171,175 @@ func promptLoginMethodSelect(pc models.PromptContent) (string, int) {
 	return result, index
 }
 
+// listLoginProviders asks the controller which login methods are enabled.
+func listLoginProviders() []models.LoginProvider {
+	log := logger.Default()
+	baseUrl, _ := storage.GetBaseUrl()
+	qrypticClient := client.NewQrypticClient(baseUrl, "")
+	statusCode, resp, err := qrypticClient.ListLoginProviders()
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	if statusCode == http.StatusNotFound {
+		return allowedLoginProviders(defaultLoginProviders)
+	}
+	if statusCode != http.StatusOK {
+		log.Error("Server Issue ...")
+		os.Exit(1)
+	}
+
+	providers := []models.LoginProvider{}
+	for _, provider := range resp.Providers {
+		if provider.Type != loginProviderPassword && provider.Type != loginProviderOIDC {
+			continue
+		}
+		if provider.DisplayName == "" {
+			provider.DisplayName = provider.Id
+		}
+		if provider.Type == loginProviderOIDC {
+			if provider.InitiatePath == "" {
+				provider.InitiatePath = fmt.Sprintf("/api/v1/auth/oidc/%s/initiate", url.PathEscape(provider.Id))
+			}
+			if provider.TokenPath == "" {
+				provider.TokenPath = fmt.Sprintf("/api/v1/auth/oidc/%s/token", url.PathEscape(provider.Id))
+			}
+			if provider.ExchangePath == "" {
+				provider.ExchangePath = fmt.Sprintf("/api/v1/auth/oidc/%s/exchange", url.PathEscape(provider.Id))
+			}
+		}
+		providers = append(providers, provider)
+	}
+	return allowedLoginProviders(providers)
+}
+
+// allowedLoginProviders drops the providers the system policy does not allow.
+func allowedLoginProviders(providers []models.LoginProvider) []models.LoginProvider {
+	allowed := []models.LoginProvider{}
+	for _, provider := range providers {
+		if storage.Policy().LoginMethodAllowed(loginProviderMethod(provider)) {
+			allowed = append(allowed, provider)
+		}
+	}
+	if len(allowed) == 0 {
+		logger.Default().Error("No login methods are enabled on this Qryptic controller, or allowed by your administrator")
+		os.Exit(1)
+	}
+	return allowed
+}
+
+// loginProviderMethod is the policy login method a provider belongs to.
+func loginProviderMethod(provider models.LoginProvider) string {
+	if provider.Type == loginProviderPassword {
+		return config.LoginMethodPassword
+	}
+	return config.LoginMethodSSO
+}
+
 func selectLoginMethod() {
 	log := logger.Default()
 	loginMethodPromptContent := models.PromptContent{
 		ErrorMsg: "Please select a valid login method.",
 		Label:    "Select a login method.",
 	}
-	loginMethod, index := promptLoginMethodSelect(loginMethodPromptContent)
+	providers := listLoginProviders()
+	loginMethod, index := promptLoginMethodSelect(loginMethodPromptContent, providers)
 	log.Info("The selected method is ", "method", loginMethod)
-	if index == 0 {
+	if providers[index].Type == loginProviderPassword {
 		loginWithEmailAndPassword()
-	} else if index == 1 {
-		loginWithGoogleSSO()
+	} else {
+		loginWithSSO(providers[index])
 	}
 }
 
-func loginWithGoogleSSO() {
+// loginWithSSO runs a PKCE authorization-code flow against the provider
+// through the controller, which holds the provider's client credentials.
+// The browser redirects back to a loopback listener; polling the controller
+// is only used when there is no browser on this machine.
+func loginWithSSO(provider models.LoginProvider) {
 	log := logger.Default()
-	codeVerifier := utils.RandomStringGenerator(40)
-	codeChallenge := utils.GetCodeChallenge(codeVerifier)
+	if NoBrowser || provider.ExchangePath == "" || !platform.HasLocalBrowser() {
+		loginWithSSOPolling(provider)
+		return
+	}
+
+	codeVerifier, codeChallenge, err := pkce.Generate()
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
 	baseUrl, _ := storage.GetBaseUrl()
-	webSSOInitiateUrl :=
-		fmt.Sprintf("%s/api/v1/auth/google/web/sso/initiate?code_challenge=%s", baseUrl, codeChallenge)
+	receiver, err := auth.NewLoopbackReceiver()
+	if err != nil {
+		log.Error(err.Error())
+		loginWithSSOPolling(provider)
+		return
+	}
+	params := url.Values{}
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", pkce.MethodS256)
+	params.Set("redirect_uri", receiver.RedirectURI())
+	params.Set("state", receiver.State)
+	webSSOInitiateUrl := fmt.Sprintf("%s%s?%s", baseUrl, provider.InitiatePath, params.Encode())
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DurationSetting(storage, config.AuthSSOTimeout))
+	defer cancel()
+	if err := platform.OpenURL(webSSOInitiateUrl); err != nil {
+		log.Error(err.Error())
+		cancel()
+		receiver.Wait(ctx)
+		loginWithSSOPolling(provider)
+		return
+	}
+	log.Info("Authenticate yourself in the browser. If it did not open, use - ", "Link", webSSOInitiateUrl)
 
-	err := platform.OpenURL(webSSOInitiateUrl)
-	log.Info("Authenticate yourself using - ", "Link", webSSOInitiateUrl)
+	code, err := receiver.Wait(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		log.Info("Authentication Failed. Try again ... ")
+		return
+	}
+	qrypticClient := client.NewQrypticClient(baseUrl, "")
+	statusCode, authResponse, err := qrypticClient.ExchangeSSOCode(provider.ExchangePath, models.SSOCodeExchangeRequest{
+		Code:         code,
+		CodeVerifier: codeVerifier,
+		RedirectUri:  receiver.RedirectURI(),
+		State:        receiver.State,
+	})
+	if err != nil {
+		log.Error(err.Error())
+		log.Info("Authentication Failed. Try again ... ")
+		return
+	}
+	if statusCode != http.StatusOK || authResponse.AuthToken == "" {
+		log.Error("Authentication Failed", "error", authResponse.Error, "message", authResponse.Message)
+		return
+	}
+	storage.SetAuthTokens(authResponse.AuthToken, authResponse.RefreshToken, authResponse.AuthTokenExpiry(time.Now()))
+	storage.SetAuthForUrl(baseUrl)
+	log.Info("Successfully Authenticated")
+}
+
+// loginWithSSOPolling prints the SSO link and polls the controller until the
+// login has been completed, possibly in a browser on another machine.
+func loginWithSSOPolling(provider models.LoginProvider) {
+	log := logger.Default()
+	codeVerifier, codeChallenge, err := pkce.Generate()
 	if err != nil {
 		log.Error(err.Error())
 		return
 	}
-	success := fetchAuthTokenCron(codeVerifier, codeChallenge)
+	baseUrl, _ := storage.GetBaseUrl()
+	webSSOInitiateUrl :=
+		fmt.Sprintf("%s%s?code_challenge=%s&code_challenge_method=%s", baseUrl, provider.InitiatePath, codeChallenge, pkce.MethodS256)
+
+	if !NoBrowser && platform.HasLocalBrowser() {
+		if err := platform.OpenURL(webSSOInitiateUrl); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	log.Info("Authenticate yourself using - ", "Link", webSSOInitiateUrl)
+	success := fetchAuthTokenCron(provider, codeVerifier, codeChallenge)
 	if success {
 		log.Info("Successfully Authenticated")
 		return
@@ -123,7 +347,7 @@ func loginWithGoogleSSO() {
 	log.Info("Authentication Failed. Try again ... ")
 }
 
-func fetchAuthTokenCron(codeVerifier, codeChallenge string) bool {
+func fetchAuthTokenCron(provider models.LoginProvider, codeVerifier, codeChallenge string) bool {
 	log := logger.Default()
 	maxDuration := 2 * time.Minute
 	interval := 5 * time.Second
@@ -142,7 +366,7 @@ func fetchAuthTokenCron(codeVerifier, codeChallenge string) bool {
 				close(resultChan)
 				return
 			case <-ticker.C:
-				success, stop := fetchAuthToken(codeVerifier, codeChallenge)
+				success, stop := fetchAuthToken(provider, codeVerifier, codeChallenge)
 				if success {
 					resultChan <- "success"
 				} else if stop {
@@ -180,7 +404,7 @@ func fetchAuthTokenCron(codeVerifier, codeChallenge string) bool {
 	}
 }
 
-func fetchAuthToken(codeVerifier, codeChallenge string) (bool, bool) {
+func fetchAuthToken(provider models.LoginProvider, codeVerifier, codeChallenge string) (bool, bool) {
 	log := logger.Default()
 	baseUrl, exists := storage.GetBaseUrl()
 	if !exists {
@@ -188,14 +412,14 @@ func fetchAuthToken(codeVerifier, codeChallenge string) (bool, bool) {
 		os.Exit(1)
 	}
 	qrypticClient := client.NewQrypticClient(baseUrl, "")
-	statuscode, authResponse, err := qrypticClient.GetWebSSOToken(codeVerifier, codeChallenge)
+	statuscode, authResponse, err := qrypticClient.GetWebSSOToken(provider.TokenPath, codeVerifier, codeChallenge)
 	if err != nil {
 		log.Error(err.Error())
 		return false, true
 	}
 	//success
 	if statuscode == http.StatusOK {
-		storage.SetAuthToken(authResponse.AuthToken)
+		storage.SetAuthTokens(authResponse.AuthToken, authResponse.RefreshToken, authResponse.AuthTokenExpiry(time.Now()))
 		storage.SetAuthForUrl(baseUrl)
 		return true, true
 	}
@@ -272,17 +496,32 @@ func promptPasswordInput(pc models.PromptContent) string {
 
 func loginWithEmailAndPassword() {
 	log := logger.Default()
-	emailIdContent := models.PromptContent{
-		ErrorMsg: "Please enter valid email id",
-		Label:    "Enter your email id",
+	emailId := loginEmail()
+	if emailId == "" {
+		requirePrompt("The email id", fmt.Sprintf("Use --email or %s", config.EnvEmail))
+		emailIdContent := models.PromptContent{
+			ErrorMsg: "Please enter valid email id",
+			Label:    "Enter your email id",
+		}
+		emailId = promptEmailInput(emailIdContent)
+	} else if !utils.IsValidEmailId(emailId) {
+		log.Error("Please enter valid email id", "email", emailId)
+		os.Exit(1)
 	}
-	emailId := promptEmailInput(emailIdContent)
 
-	passwordContent := models.PromptContent{
-		ErrorMsg: "Please enter the password",
-		Label:    "Enter your password",
+	password, err := loginPassword()
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	if password == "" {
+		requirePrompt("The password", fmt.Sprintf("Use --password-stdin or %s", config.EnvPassword))
+		passwordContent := models.PromptContent{
+			ErrorMsg: "Please enter the password",
+			Label:    "Enter your password",
+		}
+		password = promptPasswordInput(passwordContent)
 	}
-	password := promptPasswordInput(passwordContent)
 	baseUrl, exists := storage.GetBaseUrl()
 	if !exists {
 		log.Error("Please re-authenticate. BaseURL is missing currently")
@@ -300,12 +539,100 @@ func loginWithEmailAndPassword() {
 		log.Error("Error while login ...")
 		os.Exit(1)
 	}
+	if authResponse.MfaRequired {
+		authResponse = completeMfaChallenge(qrypticClient, *authResponse)
+	}
 	if authResponse.AuthToken == "" {
 		log.Error("Invalid credentials")
 		os.Exit(1)
 	}
-	storage.SetAuthToken(authResponse.AuthToken)
+	storage.SetAuthTokens(authResponse.AuthToken, authResponse.RefreshToken, authResponse.AuthTokenExpiry(time.Now()))
 	storage.SetAuthForUrl(baseUrl)
 
 	log.Info("Successfully Authenticated")
 }
+
+func loginWithDevice() {
+	log := logger.Default()
+	baseUrl, exists := storage.GetBaseUrl()
+	if !exists {
+		log.Error("Please re-authenticate. BaseURL is missing currently")
+		os.Exit(1)
+	}
+	qrypticClient := client.NewQrypticClient(baseUrl, "")
+	statusCode, deviceAuth, err := qrypticClient.StartDeviceAuthorization()
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	if statusCode != http.StatusOK || deviceAuth.DeviceCode == "" {
+		log.Error("Device login is not available on this Qryptic controller", "status", statusCode)
+		os.Exit(1)
+	}
+
+	verificationUrl := deviceAuth.VerificationUriComplete
+	if verificationUrl == "" {
+		verificationUrl = deviceAuth.VerificationUri
+	}
+	fmt.Printf("To authenticate, open %s and enter the code: %s\n", deviceAuth.VerificationUri, deviceAuth.UserCode)
+	if qrCode, err := utils.RenderQRCode(verificationUrl); err == nil {
+		fmt.Println("Or scan this QR code:")
+		fmt.Println(qrCode)
+	}
+
+	if pollDeviceToken(qrypticClient, *deviceAuth) {
+		log.Info("Successfully Authenticated")
+		return
+	}
+	log.Info("Authentication Failed. Try again ... ")
+}
+
+// pollDeviceToken polls the token endpoint as described in RFC 8628 section 3.5
+// until the user has approved or denied the login, or the device code expired.
+func pollDeviceToken(qrypticClient *client.QrypticClient, deviceAuth models.DeviceAuthorizationResponse) bool {
+	log := logger.Default()
+	interval := time.Duration(deviceAuth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiresIn := time.Duration(deviceAuth.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 10 * time.Minute
+	}
+	deadline := time.Now().Add(expiresIn)
+	tokenRequest := models.DeviceTokenRequest{
+		GrantType:  deviceCodeGrantType,
+		DeviceCode: deviceAuth.DeviceCode,
+	}
+
+	for time.Now().Add(interval).Before(deadline) {
+		time.Sleep(interval)
+		statusCode, authResponse, err := qrypticClient.GetDeviceToken(tokenRequest)
+		if err != nil {
+			log.Error(err.Error())
+			return false
+		}
+		if statusCode == http.StatusOK && authResponse.AuthToken != "" {
+			storage.SetAuthTokens(authResponse.AuthToken, authResponse.RefreshToken, authResponse.AuthTokenExpiry(time.Now()))
+			storage.SetAuthForUrl(qrypticClient.BaseURL)
+			return true
+		}
+		switch authResponse.Error {
+		case "authorization_pending":
+			log.Info("Waiting for confirmation ...")
+		case "slow_down":
+			interval += 5 * time.Second
+		case "expired_token":
+			log.Error("The code has expired before the login was confirmed")
+			return false
+		case "access_denied":
+			log.Error("The login was denied")
+			return false
+		default:
+			log.Error("Device login failed", "error", authResponse.Error, "message", authResponse.Message)
+			return false
+		}
+	}
+	log.Error("The code has expired before the login was confirmed")
+	return false
+}
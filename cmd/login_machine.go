@@ -0,0 +1,103 @@
+/*
+Copyright © 2025 Leetsecure hello@leetsecure.com
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/auth"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/logger"
+	"github.com/leetsecure/qryptic-client-cli/internal/platform"
+)
+
+var MachineLogin bool
+var ClientId string
+var ClientSecretStdin bool
+var PrivateKeyPath string
+
+// loginAsMachine authenticates this host with its machine identity. The
+// credentials are kept so that tokens can be renewed without anyone present.
+func loginAsMachine() {
+	log := logger.Default()
+	baseUrl, exists := storage.GetBaseUrl()
+	if !exists {
+		log.Error("Please re-authenticate. BaseURL is missing currently")
+		os.Exit(1)
+	}
+
+	identity, err := machineIdentityFromFlags()
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	deviceId, err := machineDeviceId()
+	if err != nil {
+		log.Error("Could not determine the device identity of this machine", "error", err.Error())
+		os.Exit(1)
+	}
+	hostname, _ := os.Hostname()
+
+	authResponse, err := auth.RequestMachineToken(baseUrl, identity, deviceId, hostname)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	storage.SetMachineIdentity(identity, deviceId)
+	storage.SetMachineToken(authResponse.AuthToken, authResponse.AuthTokenExpiry(time.Now()))
+	storage.SetAuthForUrl(baseUrl)
+
+	log.Info("Successfully Authenticated", "device", deviceId, "hostname", hostname)
+}
+
+func machineIdentityFromFlags() (config.MachineIdentity, error) {
+	identity := config.MachineIdentity{
+		ClientId:       ClientId,
+		PrivateKeyPath: PrivateKeyPath,
+	}
+	if identity.ClientId == "" {
+		identity.ClientId = os.Getenv(config.EnvClientId)
+	}
+	if identity.PrivateKeyPath == "" {
+		identity.PrivateKeyPath = os.Getenv(config.EnvPrivateKeyFile)
+	}
+	if ClientSecretStdin {
+		secret, err := stdin.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return identity, fmt.Errorf("failed to read client secret from stdin: %w", err)
+		}
+		identity.ClientSecret = strings.TrimRight(secret, "\r\n")
+	} else {
+		identity.ClientSecret = os.Getenv(config.EnvClientSecret)
+	}
+
+	if identity.ClientId == "" {
+		return identity, fmt.Errorf("machine login requires --client-id or %s", config.EnvClientId)
+	}
+	if identity.ClientSecret == "" && identity.PrivateKeyPath == "" {
+		return identity, fmt.Errorf("machine login requires --private-key (or %s), or --client-secret-stdin (or %s)", config.EnvPrivateKeyFile, config.EnvClientSecret)
+	}
+	if identity.ClientSecret != "" && identity.PrivateKeyPath != "" {
+		return identity, fmt.Errorf("use either a client secret or a private key, not both")
+	}
+	return identity, nil
+}
+
+// machineDeviceId derives the device identity from the OS machine id. It is
+// hashed so the raw machine id, which other software may rely on being
+// private, never leaves the host.
+func machineDeviceId() (string, error) {
+	machineId, err := platform.GetMachineId()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte("qryptic-device:" + machineId))
+	return hex.EncodeToString(sum[:16]), nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2025 Leetsecure hello@leetsecure.com
+*/
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/client"
+	"github.com/leetsecure/qryptic-client-cli/internal/logger"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/manifoldco/promptui"
+)
+
+var TOTPCode string
+var RecoveryCode string
+var EmailCode bool
+
+const (
+	mfaMethodTOTP     = "totp"
+	mfaMethodRecovery = "recovery_code"
+	mfaMethodEmail    = "email_otp"
+)
+
+var mfaMethodNames = map[string]string{
+	mfaMethodTOTP:     "Authenticator app code",
+	mfaMethodRecovery: "Recovery code",
+	mfaMethodEmail:    "Code sent by email",
+}
+
+var totpCodeRegex = regexp.MustCompile(`^[0-9]{6,8}$`)
+
+// completeMfaChallenge answers the second factor challenge returned by the
+// email/password login, either from flags or by prompting, and returns the
+// final auth response.
+func completeMfaChallenge(qrypticClient *client.QrypticClient, challenge models.AuthResponse) *models.AuthResponse {
+	log := logger.Default()
+	method, code := mfaCodeFromFlags()
+	if method == "" {
+		requirePrompt("A second factor", "Use --totp, --recovery-code or --email-code")
+		method = promptMfaMethodSelect(challenge.MfaMethods)
+	}
+	if !mfaMethodAllowed(challenge.MfaMethods, method) {
+		log.Error("This second factor is not enabled for your account", "method", mfaMethodNames[method])
+		os.Exit(1)
+	}
+	if method == mfaMethodEmail {
+		// The code belongs to this challenge, so it can only be sent now
+		statusCode, err := qrypticClient.SendMfaEmailCode(models.MfaEmailCodeRequest{ChallengeId: challenge.ChallengeId})
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		if statusCode != http.StatusOK && statusCode != http.StatusNoContent {
+			log.Error("Could not send the code by email", "status", statusCode)
+			os.Exit(1)
+		}
+		log.Info("A one-time code has been sent to your email")
+	}
+	if code == "" {
+		code = mfaCodeInput(method)
+	}
+
+	statusCode, authResponse, err := qrypticClient.VerifyMfa(models.MfaVerifyRequest{
+		ChallengeId: challenge.ChallengeId,
+		Method:      method,
+		Code:        code,
+	})
+	if err != nil {
+		log.Error(err.Error())
+		log.Error("Error while login ...")
+		os.Exit(1)
+	}
+	if statusCode != http.StatusOK || authResponse.AuthToken == "" {
+		log.Error("Invalid second factor code")
+		os.Exit(1)
+	}
+	return authResponse
+}
+
+// mfaCodeFromFlags returns the factor chosen by flag and its code. The email
+// code is not known yet when the command starts, so it is read later.
+func mfaCodeFromFlags() (string, string) {
+	switch {
+	case TOTPCode != "":
+		return mfaMethodTOTP, TOTPCode
+	case RecoveryCode != "":
+		return mfaMethodRecovery, RecoveryCode
+	case EmailCode:
+		return mfaMethodEmail, ""
+	}
+	return "", ""
+}
+
+// mfaCodeInput prompts for the code, or reads it as a line from stdin when
+// prompting is disabled or stdin is not a terminal.
+func mfaCodeInput(method string) string {
+	if !noPrompt() && stdinIsTerminal() {
+		return promptMfaCodeInput(method)
+	}
+	code, err := readStdinLine(mfaMethodNames[method])
+	if err != nil {
+		logger.Default().Error(err.Error())
+		os.Exit(1)
+	}
+	return code
+}
+
+// mfaMethodAllowed treats an empty list as "any method", for controllers
+// that do not advertise the enabled factors.
+func mfaMethodAllowed(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, allowed := range methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+func promptMfaMethodSelect(methods []string) string {
+	log := logger.Default()
+	if len(methods) == 0 {
+		methods = []string{mfaMethodTOTP, mfaMethodRecovery}
+	}
+	supported := []string{}
+	items := []string{}
+	for _, method := range methods {
+		if name, ok := mfaMethodNames[method]; ok {
+			supported = append(supported, method)
+			items = append(items, name)
+		}
+	}
+	if len(supported) == 0 {
+		log.Error("None of the second factors required by the controller are supported", "methods", methods)
+		os.Exit(1)
+	}
+	if len(supported) == 1 {
+		return supported[0]
+	}
+
+	prompt := promptui.Select{
+		Label: "Select a second factor",
+		Items: items,
+	}
+	index, _, err := prompt.Run()
+	if err != nil {
+		log.Error("Login failed", "error", err.Error())
+		os.Exit(1)
+	}
+	return supported[index]
+}
+
+func promptMfaCodeInput(method string) string {
+	log := logger.Default()
+	validate := func(input string) error {
+		if method == mfaMethodTOTP && !totpCodeRegex.MatchString(input) {
+			return errors.New("Please enter the code shown in your authenticator app")
+		}
+		if len(input) <= 0 {
+			return errors.New("Please enter the code")
+		}
+		return nil
+	}
+	templates := &promptui.PromptTemplates{
+		Prompt:  "{{ . }} ",
+		Valid:   "{{ . | green }} ",
+		Invalid: "{{ . | red }} ",
+		Success: "{{ . | bold }} ",
+	}
+
+	prompt := promptui.Prompt{
+		Label:     "Enter your " + mfaMethodNames[method],
+		Templates: templates,
+		Validate:  validate,
+	}
+
+	result, err := prompt.Run()
+	if err != nil {
+		log.Error("Login failed", "error", err.Error())
+		os.Exit(1)
+	}
+
+	return result
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2025 Leetsecure hello@leetsecure.com
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/client"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/logger"
+)
+
+var LoginEmail string
+var PasswordStdin bool
+var LoginToken string
+var NoPrompt bool
+
+// stdin is shared by everything read from standard input, so that a line
+// buffered while reading the password is still there for the next read.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readStdinLine reads one line from stdin, what names the value for errors.
+func readStdinLine(what string) (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read %s from stdin: %w", what, err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("expected the %s on stdin but it was empty", what)
+	}
+	return line, nil
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// loginEmail returns the email given by flag or environment, if any.
+func loginEmail() string {
+	if LoginEmail != "" {
+		return LoginEmail
+	}
+	return os.Getenv(config.EnvEmail)
+}
+
+// loginPassword returns the password read from stdin or the environment, if any.
+func loginPassword() (string, error) {
+	if PasswordStdin {
+		return readStdinLine("password")
+	}
+	return os.Getenv(config.EnvPassword), nil
+}
+
+// loginToken returns the pre-issued API token given by flag or environment, if any.
+func loginToken() string {
+	if LoginToken != "" {
+		return LoginToken
+	}
+	return os.Getenv(config.EnvToken)
+}
+
+func noPrompt() bool {
+	if NoPrompt {
+		return true
+	}
+	noPromptEnv, _ := strconv.ParseBool(os.Getenv(config.EnvNoPrompt))
+	return noPromptEnv
+}
+
+// requirePrompt exits with a precise message when input is missing and
+// prompting has been disabled.
+func requirePrompt(missing, hint string) {
+	if !noPrompt() {
+		return
+	}
+	log := logger.Default()
+	log.Error(fmt.Sprintf("%s is required but prompting is disabled by --no-prompt. %s", missing, hint))
+	os.Exit(1)
+}
+
+// loginWithToken validates a pre-issued API token with the controller and stores it.
+func loginWithToken(token string) {
+	log := logger.Default()
+	baseUrl, exists := storage.GetBaseUrl()
+	if !exists {
+		log.Error("Please re-authenticate. BaseURL is missing currently")
+		os.Exit(1)
+	}
+	qrypticClient := client.NewQrypticClient(baseUrl, token)
+	statusCode, _, err := qrypticClient.GetCurrentUser()
+	if err != nil {
+		log.Error(err.Error())
+		log.Error("Error while login ...")
+		os.Exit(1)
+	}
+	if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		log.Error("The token was rejected by the Qryptic controller")
+		os.Exit(1)
+	}
+	if statusCode != http.StatusOK {
+		log.Error("Server Issue ...", "status", statusCode)
+		os.Exit(1)
+	}
+	storage.SetAPIToken(token)
+	storage.SetAuthForUrl(baseUrl)
+
+	log.Info("Successfully Authenticated")
+}
@@ -5,29 +5,118 @@ package cmd
 
 import (
 	"fmt"
+	"net/http"
+	"os"
 
-	"github.com/leetsecure/qryptic-client-cli/internal/logger"
+	"github.com/leetsecure/qryptic-client-cli/internal/auth"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
 	"github.com/spf13/cobra"
 )
 
 var logoutCmd = &cobra.Command{
 	Use:   "logout",
 	Short: "Logout, Cleanup and Reset",
-	Long:  `This will reset your Qrytic CLI as new one and remove all the saved data along with logging you out.`,
+	Long:  `This will log you out of the active profile, revoke its credentials and remove all of its saved data.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		log := logger.Default()
-		err := wg.StopVPN()
-		if err != nil {
-			fmt.Printf("Error in stopping the running qryptic client \n %s \n", err.Error())
-			fmt.Printf("disconnect before logging out ...")
-			return
+		failed := 0
+		step := func(name string, err error) {
+			if err != nil {
+				failed++
+				fmt.Printf("  FAIL  %-32s %s\n", name, err)
+				return
+			}
+			fmt.Printf("  OK    %s\n", name)
 		}
-		storage.ClearConfig()
 
-		log.Info("Logged out ....")
+		fmt.Printf("Logging out of the %s profile\n", storage.Profile())
+		// The tunnel is shared, leave it alone when another profile brought it up.
+		if _, _, connected := storage.GetConnectedToGateway(); connected && storage.GetConnectedProfile() != storage.Profile() {
+			fmt.Printf("  SKIP  stop tunnel, it belongs to the %s profile\n", storage.GetConnectedProfile())
+		} else {
+			removedConfigs, err := wg.Cleanup(gatewayPrivateKeys())
+			if err != nil {
+				fmt.Printf("Error in stopping the running qryptic client \n %s \n", err.Error())
+				fmt.Println("disconnect before logging out ...")
+				os.Exit(1)
+			}
+			step("stop tunnel", nil)
+			if wg.IsKillSwitchEnabled() {
+				fmt.Println("  SKIP  disable kill switch, only qryptic disconnect removes it")
+			}
+			for _, path := range removedConfigs {
+				step("remove "+path, nil)
+			}
+			step("clear connection state", storage.ClearConnectedToGateway())
+		}
+
+		revokeCredentials(storage, step)
+
+		step("remove credentials and cached gateway clients", storage.ClearProfile())
+
+		if failed > 0 {
+			fmt.Printf("Logged out with %d step(s) failed\n", failed)
+			os.Exit(1)
+		}
+		fmt.Println("Logged out ....")
 	},
 }
 
+// gatewayPrivateKeys returns the client keys of the gateways cached in any
+// profile.
+func gatewayPrivateKeys() []string {
+	privateKeys := []string{}
+	for _, profile := range storage.ListProfiles() {
+		profileStorage := storage.ForProfile(profile)
+		for _, uuid := range profileStorage.GetQrypticClientUuids() {
+			clientConfig, err := profileStorage.GetQrypticClient(uuid)
+			if err == nil && clientConfig.WGClientInterfaceConfig.ClientPrivateKey != "" {
+				privateKeys = append(privateKeys, clientConfig.WGClientInterfaceConfig.ClientPrivateKey)
+			}
+		}
+	}
+	return privateKeys
+}
+
+// revokeCredentials revokes the profile's gateway clients and tokens at the
+// controller. Gateway clients are revoked by the UUID as the controller
+// issued it, the store keeps its case.
+func revokeCredentials(storage config.Store, step func(name string, err error)) {
+	authToken, loggedIn := storage.GetAuthToken()
+	if !loggedIn {
+		return
+	}
+	qrypticClient := auth.NewAuthenticatedClient(storage)
+	for _, uuid := range storage.GetQrypticClientUuids() {
+		statusCode, err := qrypticClient.RevokeGatewayClient(uuid)
+		step("revoke gateway client "+uuid, revokeError(statusCode, err))
+	}
+	if refreshToken, exists := storage.GetRefreshToken(); exists {
+		statusCode, err := qrypticClient.RevokeToken(models.RevokeTokenRequest{Token: refreshToken, TokenTypeHint: "refresh_token"})
+		step("revoke refresh token", revokeError(statusCode, err))
+	}
+	// The client may have refreshed the token while revoking the gateway clients.
+	if qrypticClient.AuthToken != "" {
+		authToken = qrypticClient.AuthToken
+	}
+	statusCode, err := qrypticClient.RevokeToken(models.RevokeTokenRequest{Token: authToken, TokenTypeHint: "access_token"})
+	step("revoke auth token", revokeError(statusCode, err))
+}
+
+// revokeError treats a token or client the controller no longer knows as
+// revoked. A 401 is a failure, the credentials were not accepted and may
+// still be valid.
+func revokeError(statusCode int, err error) error {
+	if err != nil {
+		return err
+	}
+	switch statusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound, http.StatusGone:
+		return nil
+	}
+	return fmt.Errorf("controller returned status %d", statusCode)
+}
+
 func init() {
 	rootCmd.AddCommand(logoutCmd)
 }
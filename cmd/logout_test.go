@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+func TestRevokeCredentialsKeepsUuidCase(t *testing.T) {
+	const uuid = "3F2A9C1E-0000-4000-8000-00000000000A"
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if r.Method == http.MethodDelete && r.URL.Path != "/api/v1/gateway/"+uuid+"/client" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	store := config.NewMemoryStore()
+	if err := store.SetBaseUrl(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetAuthTokens("opaque-token", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetQrypticClient(uuid, models.WGClientConfig{ClientUuid: "client-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := map[string]error{}
+	revokeCredentials(store, func(name string, err error) { steps[name] = err })
+
+	if err, ok := steps["revoke gateway client "+uuid]; !ok || err != nil {
+		t.Errorf("gateway client revocation: %v (ran %v)", err, ok)
+	}
+	sort.Strings(paths)
+	want := []string{"DELETE /api/v1/gateway/" + uuid + "/client", "POST /api/v1/auth/revoke"}
+	if len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("requests %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2025 Leetsecure hello@leetsecure.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+var ProfileURL string
+
+// profileCmd represents the profile command
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage controller profiles",
+	Long:  `Keep the base URL, credentials and cached gateway clients of several Qryptic controllers side by side and switch between them`,
+}
+
+var profileAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a profile",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := strings.ToLower(args[0])
+		baseUrl := strings.TrimSuffix(ProfileURL, "/")
+		if baseUrl != "" && !auth.IsURL(baseUrl) {
+			fmt.Println("Please make sure the url is in format : http[s]://<domain/subdomain>")
+			os.Exit(1)
+		}
+		if err := storage.AddProfile(name, baseUrl); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Profile %s added, log in with: qryptic --profile %s login\n", name, name)
+	},
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		active := storage.GetActiveProfile()
+		for _, name := range storage.ListProfiles() {
+			marker := " "
+			if name == active {
+				marker = "*"
+			}
+			profile := storage.ForProfile(name)
+			baseUrl, _ := profile.GetBaseUrl()
+			status := "logged out"
+			if _, loggedIn := profile.GetAuthToken(); loggedIn {
+				status = "logged in"
+			}
+			fmt.Printf("%s %-16s %-40s %s\n", marker, name, baseUrl, status)
+		}
+	},
+}
+
+var profileUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Make a profile the active one",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := strings.ToLower(args[0])
+		if err := storage.UseProfile(name); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Switched to profile %s\n", name)
+	},
+}
+
+var profileRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a profile and its stored credentials",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := strings.ToLower(args[0])
+		if _, _, connected := storage.GetConnectedToGateway(); connected && storage.GetConnectedProfile() == name {
+			fmt.Printf("Profile %s is connected, disconnect before removing it\n", name)
+			os.Exit(1)
+		}
+		if err := storage.RemoveProfile(name); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Profile %s removed\n", name)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileAddCmd, profileListCmd, profileUseCmd, profileRemoveCmd)
+	profileAddCmd.Flags().StringVarP(&ProfileURL, "url", "u", "", "Url of the profile's qryptic controller")
+}
@@ -12,12 +12,23 @@ import (
 	"github.com/leetsecure/qryptic-client-cli/internal/platform"
 	"github.com/leetsecure/qryptic-client-cli/internal/wireguard"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-var storage *config.Storage
+var storage config.Store
 var wg *wireguard.WireGuardManager
 
+var Profile string
+
+// profileFlags are bound once the profile, and so the config key they
+// override, is known.
+var profileFlags = map[string]*pflag.Flag{}
+
+func bindProfileFlag(key string, flag *pflag.Flag) {
+	profileFlags[key] = flag
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "qryptic",
 	Short: "Client CLI for Qryptic",
@@ -34,6 +45,7 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().StringVar(&Profile, "profile", "", "Controller profile to use instead of the active one")
 	if os.Geteuid() != 0 {
 		fmt.Println("Please run as a root user or with sudo permission")
 		os.Exit(1)
@@ -41,12 +53,16 @@ func init() {
 }
 
 func initConfig() {
-	storageRes, err := config.NewStorage(viper.GetViper())
+	storageRes, err := config.NewStore(viper.GetViper(), Profile)
 	if err != nil {
 		fmt.Println("Error in setting up/accessing the config file")
+		fmt.Println(err)
 		os.Exit(1)
 	}
 	storage = storageRes
+	for key, flag := range profileFlags {
+		storage.BindPFlag(key, flag)
+	}
 	wg = wireguard.NewWireGuardManager(platform.GetConfigDirectory(), platform.GetDefaultInterfaceName())
 
 }
@@ -19,11 +19,14 @@ var statusCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		uuid, name, exists := storage.GetConnectedToGateway()
 		if exists {
-			wgclientConfig, _ := storage.GetQrypticClient(uuid)
-			fmt.Printf("Connected to %s gateway at %s:%d\n", name, wgclientConfig.WGClientPeerConfig.VpnGatewayIP, wgclientConfig.WGClientPeerConfig.VpnGatewayPort)
+			wgclientConfig, _ := storage.ForProfile(storage.GetConnectedProfile()).GetQrypticClient(uuid)
+			fmt.Printf("Connected to %s gateway at %s\n", name, wgclientConfig.WGClientPeerConfig.Endpoint())
 		} else {
 			fmt.Println("Qryptic is not running")
 		}
+		if wg.IsKillSwitchEnabled() {
+			fmt.Println("Kill switch is active, traffic outside the tunnel is blocked")
+		}
 		if !StatusDebug {
 			return
 		}
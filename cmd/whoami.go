@@ -0,0 +1,182 @@
+/*
+Copyright © 2025 Leetsecure hello@leetsecure.com
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/leetsecure/qryptic-client-cli/internal/auth"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var WhoamiOutput string
+var WhoamiRemote bool
+
+// whoami is the identity reported by the whoami command.
+type whoami struct {
+	BaseUrl      string     `json:"baseUrl"`
+	TokenType    string     `json:"tokenType"`
+	Subject      string     `json:"subject,omitempty"`
+	Email        string     `json:"email,omitempty"`
+	Name         string     `json:"name,omitempty"`
+	Organisation string     `json:"organisation,omitempty"`
+	Roles        []string   `json:"roles,omitempty"`
+	IssuedAt     *time.Time `json:"issuedAt,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	ExpiresSoon  bool       `json:"expiresSoon"`
+	Refreshable  bool       `json:"refreshable"`
+}
+
+// whoamiCmd represents the whoami command
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the logged in identity",
+	Long:  `Show the controller, account, organisation and token lifetime the Qryptic CLI is logged in with`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if WhoamiOutput != "text" && WhoamiOutput != "json" {
+			fmt.Printf("Unsupported output format %q, use text or json\n", WhoamiOutput)
+			os.Exit(1)
+		}
+		if !auth.EnsureAuthTokenValid(storage) {
+			fmt.Println("You are not logged in, run qryptic login")
+			os.Exit(1)
+		}
+		baseUrl, _ := storage.GetBaseUrl()
+		authToken, _ := storage.GetAuthToken()
+		_, refreshable := storage.GetRefreshToken()
+		identity := whoami{
+			BaseUrl:     baseUrl,
+			TokenType:   storage.GetAuthTokenType(),
+			Refreshable: refreshable || identityIsMachine(),
+		}
+
+		// API tokens are opaque, only the controller knows who they belong to.
+		remote := WhoamiRemote || identity.TokenType == config.AuthTokenTypeAPI
+		if identity.TokenType != config.AuthTokenTypeAPI {
+			claims, err := auth.VerifyAuthToken(storage, authToken)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			identity.fromClaims(claims)
+		}
+		if remote {
+			if err := identity.fromController(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		if identity.ExpiresAt != nil && time.Until(*identity.ExpiresAt) < config.AuthTokenExpiryWarning {
+			identity.ExpiresSoon = true
+			if !identity.Refreshable {
+				fmt.Fprintf(os.Stderr, "Warning: the auth token expires in %s, run qryptic login to renew it\n", time.Until(*identity.ExpiresAt).Round(time.Second))
+			}
+		}
+
+		if WhoamiOutput == "json" {
+			out, _ := json.MarshalIndent(identity, "", "  ")
+			fmt.Println(string(out))
+			return
+		}
+		identity.print()
+	},
+}
+
+func identityIsMachine() bool {
+	_, _, exists := storage.GetMachineIdentity()
+	return exists && storage.GetAuthTokenType() == config.AuthTokenTypeMachine
+}
+
+func (w *whoami) fromClaims(claims jwt.MapClaims) {
+	w.Subject, _ = claims.GetSubject()
+	w.Email, _ = claims["email"].(string)
+	w.Name, _ = claims["name"].(string)
+	for _, key := range []string{"organisation", "org"} {
+		if organisation, ok := claims[key].(string); ok && organisation != "" {
+			w.Organisation = organisation
+			break
+		}
+	}
+	if roles, ok := claims["roles"].([]interface{}); ok {
+		for _, role := range roles {
+			if role, ok := role.(string); ok {
+				w.Roles = append(w.Roles, role)
+			}
+		}
+	}
+	if issuedAt, err := claims.GetIssuedAt(); err == nil && issuedAt != nil {
+		w.IssuedAt = &issuedAt.Time
+	}
+	if expiresAt, err := claims.GetExpirationTime(); err == nil && expiresAt != nil {
+		w.ExpiresAt = &expiresAt.Time
+	}
+}
+
+// fromController fills in the profile the controller reports, which wins
+// over the token claims since it reflects changes made after login.
+func (w *whoami) fromController() error {
+	qrypticClient := auth.NewAuthenticatedClient(storage)
+	statusCode, currentUser, err := qrypticClient.GetCurrentUser()
+	if err != nil {
+		return fmt.Errorf("failed to reach the controller: %w", err)
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("controller returned status %d for the current user", statusCode)
+	}
+	if currentUser.Email != "" {
+		w.Email = currentUser.Email
+	}
+	if currentUser.Name != "" {
+		w.Name = currentUser.Name
+	}
+	if currentUser.Organisation != "" {
+		w.Organisation = currentUser.Organisation
+	}
+	if len(currentUser.Roles) > 0 {
+		w.Roles = currentUser.Roles
+	}
+	return nil
+}
+
+func (w *whoami) print() {
+	fmt.Printf("Controller:   %s\n", w.BaseUrl)
+	account := w.Email
+	if account == "" {
+		account = w.Subject
+	}
+	if w.Name != "" {
+		account = fmt.Sprintf("%s (%s)", account, w.Name)
+	}
+	fmt.Printf("Account:      %s\n", account)
+	if w.Organisation != "" {
+		fmt.Printf("Organisation: %s\n", w.Organisation)
+	}
+	if len(w.Roles) > 0 {
+		fmt.Printf("Roles:        %s\n", strings.Join(w.Roles, ", "))
+	}
+	fmt.Printf("Token type:   %s\n", w.TokenType)
+	if w.IssuedAt != nil {
+		fmt.Printf("Issued at:    %s\n", w.IssuedAt.Local().Format(time.RFC1123))
+	}
+	if w.ExpiresAt != nil {
+		fmt.Printf("Expires at:   %s (in %s)\n", w.ExpiresAt.Local().Format(time.RFC1123), time.Until(*w.ExpiresAt).Round(time.Second))
+	}
+	if w.ExpiresSoon && w.Refreshable {
+		fmt.Println("The token expires soon and will be renewed automatically")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(whoamiCmd)
+	whoamiCmd.Flags().StringVarP(&WhoamiOutput, "output", "o", "text", "Output format, text or json")
+	whoamiCmd.Flags().BoolVar(&WhoamiRemote, "remote", false, "Also ask the controller for the current profile")
+}
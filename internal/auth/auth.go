@@ -1,26 +1,57 @@
 package auth
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/leetsecure/qryptic-client-cli/internal/client"
 	"github.com/leetsecure/qryptic-client-cli/internal/config"
-	"github.com/spf13/viper"
 )
 
-func IsAuthTokenValid() bool {
-	authToken := viper.GetViper().GetString(config.AuthToken)
+var ErrMissingAuthToken = errors.New("no auth token stored")
+
+// signingMethods are the asymmetric algorithms accepted from the controller.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+func IsAuthTokenValid(storage config.Store) bool {
+	authToken, _ := storage.GetAuthToken()
+	if storage.GetAuthTokenType() == config.AuthTokenTypeAPI {
+		// API tokens are opaque, the controller rejects them if they are revoked.
+		return authToken != ""
+	}
+	_, err := VerifyAuthToken(storage, authToken)
+	return err == nil
+}
+
+// VerifyAuthToken checks the token's signature against the JWKS of the
+// profile's controller and validates its expiry, issuer and audience,
+// allowing for clock skew.
+func VerifyAuthToken(storage config.Store, authToken string) (jwt.MapClaims, error) {
 	if authToken == "" {
-		return false
+		return nil, ErrMissingAuthToken
 	}
+	baseUrl, _ := storage.GetBaseUrl()
+	issuer := storage.GetAuthIssuer()
+	if issuer == "" {
+		issuer = baseUrl
+	}
+	audience := storage.GetAuthAudience()
+
 	claims := jwt.MapClaims{}
-	jwt.ParseWithClaims(authToken, claims, nil)
-	expiryTime, _ := claims.GetExpirationTime()
-	currentTime := time.Now()
-	return !expiryTime.Time.Before(currentTime)
+	_, err := jwt.ParseWithClaims(authToken, claims, keyfuncFor(baseUrl),
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(config.DurationSetting(storage, config.AuthClockSkew)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth token: %w", err)
+	}
+	return claims, nil
 }
 
 func IsURL(urlToCheck string) bool {
@@ -28,9 +59,8 @@ func IsURL(urlToCheck string) bool {
 	return err == nil
 }
 
-func IsBaseUrlHealthy() bool {
+func IsBaseUrlHealthy(baseUrl string) bool {
 	// log := logger.Default()
-	baseUrl := viper.GetViper().GetString(config.BaseUrl)
 
 	qrypticClient := client.NewQrypticClient(baseUrl, "")
 	statusCode, response, err := qrypticClient.ControllerHealthCheck()
@@ -43,7 +73,3 @@ func IsBaseUrlHealthy() bool {
 	}
 	return false
 }
-
-func GenerateCodeVerifierAndChallenge() (string, string) {
-	return "", ""
-}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/leetsecure/qryptic-client-cli/internal/client"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+var ErrUnknownSigningKey = errors.New("token is signed with an unknown key")
+var ErrSigningAlgMismatch = errors.New("token algorithm does not match its signing key")
+
+// jwksCacheEntry is the on-disk form of a controller's key set.
+type jwksCacheEntry struct {
+	BaseUrl   string              `json:"baseUrl"`
+	FetchedAt time.Time           `json:"fetchedAt"`
+	JWKS      models.JWKSResponse `json:"jwks"`
+}
+
+// keySet holds the parsed public keys of one controller.
+type keySet struct {
+	fetchedAt time.Time
+	keys      map[string]signingKey
+}
+
+// signingKey is a public key and the algorithm it is published for, if any.
+type signingKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+var keySetsMu sync.Mutex
+var keySets = map[string]*keySet{}
+
+// keyfuncFor returns a jwt.Keyfunc that resolves signing keys from the JWKS
+// published by the controller at baseUrl. Keys are cached in memory and on
+// disk, and refetched once when a token names a key that is not cached.
+func keyfuncFor(baseUrl string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		set, err := loadKeySet(baseUrl, false)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := set.lookup(kid)
+		if !ok {
+			// The controller may have rotated its keys since the cache was filled.
+			set, err = loadKeySet(baseUrl, true)
+			if err != nil {
+				return nil, err
+			}
+			if key, ok = set.lookup(kid); !ok {
+				return nil, ErrUnknownSigningKey
+			}
+		}
+		// A key published for one algorithm must not verify another, e.g. an
+		// RSA key meant for RS256 used for PS256
+		if key.alg != "" && key.alg != token.Method.Alg() {
+			return nil, fmt.Errorf("%w: key %q is for %s, token uses %s", ErrSigningAlgMismatch, kid, key.alg, token.Method.Alg())
+		}
+		return key.key, nil
+	}
+}
+
+func (s *keySet) lookup(kid string) (signingKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func loadKeySet(baseUrl string, refresh bool) (*keySet, error) {
+	keySetsMu.Lock()
+	defer keySetsMu.Unlock()
+
+	set, ok := keySets[baseUrl]
+	if ok && !refresh && time.Since(set.fetchedAt) < config.JWKSCacheTTL {
+		return set, nil
+	}
+	if !ok && !refresh {
+		if entry, err := readJWKSCache(baseUrl); err == nil && time.Since(entry.FetchedAt) < config.JWKSCacheTTL {
+			set = parseKeySet(entry.JWKS, entry.FetchedAt)
+			keySets[baseUrl] = set
+			return set, nil
+		}
+	}
+
+	qrypticClient := client.NewQrypticClient(baseUrl, "")
+	statusCode, jwks, err := qrypticClient.GetJWKS()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch signing keys: status %d", statusCode)
+	}
+	fetchedAt := time.Now()
+	set = parseKeySet(*jwks, fetchedAt)
+	keySets[baseUrl] = set
+	writeJWKSCache(jwksCacheEntry{BaseUrl: baseUrl, FetchedAt: fetchedAt, JWKS: *jwks})
+	return set, nil
+}
+
+// parseKeySet converts the published keys, skipping ones that are not for
+// signatures or that cannot be decoded.
+func parseKeySet(jwks models.JWKSResponse, fetchedAt time.Time) *keySet {
+	set := &keySet{fetchedAt: fetchedAt, keys: map[string]signingKey{}}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parsePublicKey(jwk)
+		if err != nil {
+			continue
+		}
+		set.keys[jwk.Kid] = signingKey{key: key, alg: jwk.Alg}
+	}
+	return set
+}
+
+func parsePublicKey(jwk models.JSONWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > int64(^uint32(0)>>1) {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func jwksCachePath(baseUrl string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(baseUrl))
+	return filepath.Join(cacheDir, "qryptic", "jwks-"+hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+func readJWKSCache(baseUrl string) (*jwksCacheEntry, error) {
+	path, err := jwksCachePath(baseUrl)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry jwksCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if entry.BaseUrl != baseUrl {
+		return nil, errors.New("cache entry belongs to a different controller")
+	}
+	return &entry, nil
+}
+
+func writeJWKSCache(entry jwksCacheEntry) error {
+	path, err := jwksCachePath(entry.BaseUrl)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
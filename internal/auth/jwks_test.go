@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+// jwksServer is a stub controller publishing a key set.
+type jwksServer struct {
+	*httptest.Server
+	jwks     atomic.Value
+	requests atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, keys ...models.JSONWebKey) *jwksServer {
+	t.Helper()
+	// Keep the on-disk JWKS cache out of the user's cache directory
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	s := &jwksServer{}
+	s.setKeys(keys...)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/jwks.json" {
+			http.NotFound(w, r)
+			return
+		}
+		s.requests.Add(1)
+		json.NewEncoder(w).Encode(s.jwks.Load())
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...models.JSONWebKey) {
+	s.jwks.Store(models.JWKSResponse{Keys: keys})
+}
+
+func rsaJWK(t *testing.T, kid, alg string) (*rsa.PrivateKey, models.JSONWebKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, models.JSONWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: alg,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(t *testing.T, kid string) (*ecdsa.PrivateKey, models.JSONWebKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, models.JSONWebKey{
+		Kty: "EC",
+		Kid: kid,
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func storeFor(baseUrl, issuer, audience string) config.Store {
+	store := config.NewMemoryStore()
+	store.SetBaseUrl(baseUrl)
+	if issuer != "" {
+		store.SetSetting(config.AuthIssuer, issuer)
+	}
+	if audience != "" {
+		store.SetSetting(config.AuthAudience, audience)
+	}
+	return store
+}
+
+func validClaims(issuer string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "user-1",
+		"iss": issuer,
+		"aud": "qryptic-cli",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+}
+
+func TestVerifyAuthToken(t *testing.T) {
+	rsaKey, rsaPublic := rsaJWK(t, "rsa-1", "RS256")
+	ecKey, ecPublic := ecJWK(t, "ec-1")
+	server := newJWKSServer(t, rsaPublic, ecPublic)
+	store := storeFor(server.URL, "", "qryptic-cli")
+	skew := config.JWTClockSkew
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    interface{}
+		claims func(jwt.MapClaims)
+		ok     bool
+	}{
+		{name: "valid RS256", method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, ok: true},
+		{name: "valid ES256 without alg in the JWK", method: jwt.SigningMethodES256, kid: "ec-1", key: ecKey, ok: true},
+		{name: "JWK alg mismatch", method: jwt.SigningMethodPS256, kid: "rsa-1", key: rsaKey},
+		{name: "unknown kid", method: jwt.SigningMethodRS256, kid: "rsa-2", key: rsaKey},
+		{name: "HMAC not accepted", method: jwt.SigningMethodHS256, kid: "rsa-1", key: []byte("secret")},
+		{name: "wrong issuer", method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey,
+			claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{name: "wrong audience", method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey,
+			claims: func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{name: "missing expiry", method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey,
+			claims: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "expired within clock skew", method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, ok: true,
+			claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-skew / 2).Unix() }},
+		{name: "expired beyond clock skew", method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey,
+			claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * skew).Unix() }},
+		{name: "not yet valid within clock skew", method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, ok: true,
+			claims: func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(skew / 2).Unix() }},
+		{name: "not yet valid beyond clock skew", method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey,
+			claims: func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(2 * skew).Unix() }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := validClaims(server.URL)
+			if test.claims != nil {
+				test.claims(claims)
+			}
+			_, err := VerifyAuthToken(store, signToken(t, test.method, test.kid, test.key, claims))
+			if test.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("token accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyAuthTokenAlgMismatchError(t *testing.T) {
+	rsaKey, rsaPublic := rsaJWK(t, "rsa-1", "RS256")
+	server := newJWKSServer(t, rsaPublic)
+	store := storeFor(server.URL, "", "qryptic-cli")
+
+	_, err := VerifyAuthToken(store, signToken(t, jwt.SigningMethodRS512, "rsa-1", rsaKey, validClaims(server.URL)))
+	if !errors.Is(err, ErrSigningAlgMismatch) {
+		t.Errorf("got %v, want ErrSigningAlgMismatch", err)
+	}
+}
+
+func TestVerifyAuthTokenConfiguredIssuer(t *testing.T) {
+	rsaKey, rsaPublic := rsaJWK(t, "rsa-1", "RS256")
+	server := newJWKSServer(t, rsaPublic)
+	store := storeFor(server.URL, "https://login.example", "qryptic-cli")
+
+	if _, err := VerifyAuthToken(store, signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims("https://login.example"))); err != nil {
+		t.Errorf("token from the configured issuer rejected: %v", err)
+	}
+	if _, err := VerifyAuthToken(store, signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims(server.URL))); err == nil {
+		t.Error("token from the controller accepted although another issuer is configured")
+	}
+}
+
+func TestVerifyAuthTokenKeyRotation(t *testing.T) {
+	oldKey, oldPublic := rsaJWK(t, "old", "RS256")
+	newKey, newPublic := rsaJWK(t, "new", "RS256")
+	server := newJWKSServer(t, oldPublic)
+	store := storeFor(server.URL, "", "qryptic-cli")
+
+	if _, err := VerifyAuthToken(store, signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims(server.URL))); err != nil {
+		t.Fatal(err)
+	}
+	server.setKeys(oldPublic, newPublic)
+	if _, err := VerifyAuthToken(store, signToken(t, jwt.SigningMethodRS256, "new", newKey, validClaims(server.URL))); err != nil {
+		t.Errorf("token signed with a rotated key rejected: %v", err)
+	}
+	if requests := server.requests.Load(); requests != 2 {
+		t.Errorf("JWKS fetched %d times, want once and once more after the rotation", requests)
+	}
+}
+
+func TestVerifyAuthTokenMalformed(t *testing.T) {
+	_, rsaPublic := rsaJWK(t, "rsa-1", "RS256")
+	server := newJWKSServer(t, rsaPublic)
+	store := storeFor(server.URL, "", "qryptic-cli")
+
+	for _, token := range []string{"", "not-a-jwt", "a.b.c", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ4In0."} {
+		if _, err := VerifyAuthToken(store, token); err == nil {
+			t.Errorf("%q accepted", token)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const loopbackCallbackPath = "/callback"
+
+const loopbackResponsePage = `<!DOCTYPE html>
+<html><head><title>Qryptic</title></head>
+<body><p>%s</p><p>You can close this window and return to the terminal.</p></body></html>`
+
+var ErrStateMismatch = errors.New("state parameter does not match, the login request may have been forged")
+
+type loopbackResult struct {
+	code string
+	err  error
+}
+
+// LoopbackReceiver is a one-shot HTTP listener on 127.0.0.1 that receives the
+// authorization code from the browser redirect of an SSO login.
+type LoopbackReceiver struct {
+	State    string
+	listener net.Listener
+	server   *http.Server
+	result   chan loopbackResult
+	once     sync.Once
+}
+
+// NewLoopbackReceiver starts listening on a random loopback port.
+func NewLoopbackReceiver() (*LoopbackReceiver, error) {
+	state, err := generateState()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start loopback listener: %w", err)
+	}
+	r := &LoopbackReceiver{
+		State:    state,
+		listener: listener,
+		result:   make(chan loopbackResult, 1),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(loopbackCallbackPath, r.handleCallback)
+	r.server = &http.Server{Handler: mux}
+	go r.server.Serve(listener)
+	return r, nil
+}
+
+// RedirectURI is the URI the provider has to redirect the browser to.
+func (r *LoopbackReceiver) RedirectURI() string {
+	return fmt.Sprintf("http://%s%s", r.listener.Addr().String(), loopbackCallbackPath)
+}
+
+// Wait blocks until the redirect has been received or ctx is done, then stops the listener.
+func (r *LoopbackReceiver) Wait(ctx context.Context) (string, error) {
+	defer func() {
+		// Let the browser receive the response page before the listener goes away.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		r.server.Shutdown(shutdownCtx)
+	}()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case result := <-r.result:
+		return result.code, result.err
+	}
+}
+
+func (r *LoopbackReceiver) handleCallback(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	state := query.Get("state")
+	if subtle.ConstantTimeCompare([]byte(state), []byte(r.State)) != 1 {
+		// Not counted as the one shot, so a stray request cannot abort the login.
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, loopbackResponsePage, "Invalid login request.")
+		return
+	}
+
+	result := loopbackResult{code: query.Get("code")}
+	message := "Authentication completed."
+	if providerError := query.Get("error"); providerError != "" {
+		result = loopbackResult{err: fmt.Errorf("login failed: %s %s", providerError, query.Get("error_description"))}
+		message = "Authentication failed."
+	} else if result.code == "" {
+		result = loopbackResult{err: errors.New("login failed: no authorization code received")}
+		message = "Authentication failed."
+	}
+	fmt.Fprintf(w, loopbackResponsePage, message)
+	r.once.Do(func() {
+		r.result <- result
+	})
+}
+
+// generateState returns an unguessable value binding the redirect to this login.
+func generateState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
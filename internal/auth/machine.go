@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/leetsecure/qryptic-client-cli/internal/client"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+const (
+	clientCredentialsGrantType = "client_credentials"
+	jwtBearerAssertionType     = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionLifetime    = 5 * time.Minute
+)
+
+// RequestMachineToken obtains a token for a machine identity, authenticating
+// with its client secret or with a private key signing a JWT assertion
+// (RFC 7523). deviceId and hostname are recorded by the controller so that
+// gateway access can be granted to the host rather than to a person.
+func RequestMachineToken(baseUrl string, credentials config.MachineIdentity, deviceId, hostname string) (*models.AuthResponse, error) {
+	if credentials.ClientId == "" {
+		return nil, errors.New("machine login requires a client id")
+	}
+	qrypticClient := client.NewQrypticClient(baseUrl, "")
+	tokenRequest := models.MachineTokenRequest{
+		GrantType: clientCredentialsGrantType,
+		ClientId:  credentials.ClientId,
+		DeviceId:  deviceId,
+		Hostname:  hostname,
+	}
+	switch {
+	case credentials.PrivateKeyPath != "":
+		key, err := loadSigningKey(credentials.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		assertion, err := buildClientAssertion(credentials.ClientId, qrypticClient.MachineTokenURL(), key)
+		if err != nil {
+			return nil, err
+		}
+		tokenRequest.ClientAssertionType = jwtBearerAssertionType
+		tokenRequest.ClientAssertion = assertion
+	case credentials.ClientSecret != "":
+		tokenRequest.ClientSecret = credentials.ClientSecret
+	default:
+		return nil, errors.New("machine login requires a client secret or a private key")
+	}
+
+	statusCode, authResponse, err := qrypticClient.GetMachineToken(tokenRequest)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK || authResponse.AuthToken == "" {
+		return nil, fmt.Errorf("machine login rejected by controller (status %d): %s %s", statusCode, authResponse.Error, authResponse.Message)
+	}
+	return authResponse, nil
+}
+
+// RenewMachineToken requests a new token with the stored machine credentials
+// and stores it. Used in place of a refresh token for machine identities.
+func RenewMachineToken(storage config.Store) (string, error) {
+	credentials, deviceId, exists := storage.GetMachineIdentity()
+	if !exists {
+		return "", errors.New("no machine identity stored, please login again")
+	}
+	baseUrl, _ := storage.GetBaseUrl()
+	hostname, _ := os.Hostname()
+	authResponse, err := RequestMachineToken(baseUrl, credentials, deviceId, hostname)
+	if err != nil {
+		return "", err
+	}
+	if err := storage.SetMachineToken(authResponse.AuthToken, authResponse.AuthTokenExpiry(time.Now())); err != nil {
+		return "", fmt.Errorf("failed to store machine token: %w", err)
+	}
+	return authResponse.AuthToken, nil
+}
+
+// buildClientAssertion signs the client authentication JWT of RFC 7523 section 2.2.
+func buildClientAssertion(clientId, audience string, key crypto.Signer) (string, error) {
+	method, err := signingMethodFor(key)
+	if err != nil {
+		return "", err
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("failed to generate assertion id: %w", err)
+	}
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    clientId,
+		Subject:   clientId,
+		Audience:  jwt.ClaimStrings{audience},
+		ID:        hex.EncodeToString(jti),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}
+	assertion, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	return assertion, nil
+}
+
+func signingMethodFor(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return jwt.SigningMethodES256, nil
+		case 384:
+			return jwt.SigningMethodES384, nil
+		case 521:
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported EC curve %s", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// loadSigningKey reads a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key.
+func loadSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
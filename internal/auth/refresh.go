@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/client"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+var ErrNoRefreshToken = errors.New("no refresh token stored, please login again")
+
+// RefreshAuthToken exchanges the stored refresh token for a new auth token and
+// persists the rotated pair. It runs under the config lock so that concurrent
+// CLI processes refresh only once: whoever comes second finds the token
+// already replaced and uses it instead of spending the rotated refresh token.
+func RefreshAuthToken(storage config.Store, rejectedToken string) (string, error) {
+	unlock, err := storage.Lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if err := storage.Reload(); err != nil {
+		return "", fmt.Errorf("failed to reload config: %w", err)
+	}
+
+	currentToken, _ := storage.GetAuthToken()
+	if currentToken != "" && currentToken != rejectedToken {
+		return currentToken, nil
+	}
+	if storage.GetAuthTokenType() == config.AuthTokenTypeMachine {
+		return RenewMachineToken(storage)
+	}
+	refreshToken, exists := storage.GetRefreshToken()
+	if !exists {
+		return "", ErrNoRefreshToken
+	}
+	baseUrl, _ := storage.GetBaseUrl()
+
+	qrypticClient := client.NewQrypticClient(baseUrl, "")
+	statusCode, authResponse, err := qrypticClient.RefreshToken(models.RefreshTokenRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return "", err
+	}
+	if statusCode != http.StatusOK || authResponse.AuthToken == "" {
+		if statusCode == http.StatusUnauthorized || statusCode == http.StatusBadRequest {
+			// The refresh token is expired or revoked and will never work again.
+			storage.SetAuthTokens("", "", time.Time{})
+		}
+		return "", fmt.Errorf("refresh rejected by controller (status %d): %s", statusCode, authResponse.Message)
+	}
+
+	// Controllers that do not rotate refresh tokens leave the field empty.
+	if authResponse.RefreshToken != "" {
+		refreshToken = authResponse.RefreshToken
+	}
+	if err := storage.SetAuthTokens(authResponse.AuthToken, refreshToken, authResponse.AuthTokenExpiry(time.Now())); err != nil {
+		return "", fmt.Errorf("failed to store refreshed tokens: %w", err)
+	}
+	return authResponse.AuthToken, nil
+}
+
+// EnsureAuthTokenValid reports whether a valid auth token is available,
+// silently refreshing an expired one when a refresh token is stored.
+func EnsureAuthTokenValid(storage config.Store) bool {
+	if IsAuthTokenValid(storage) {
+		return true
+	}
+	authToken, _ := storage.GetAuthToken()
+	if _, err := RefreshAuthToken(storage, authToken); err != nil {
+		return false
+	}
+	return IsAuthTokenValid(storage)
+}
+
+// NewAuthenticatedClient returns a client for the stored controller and token
+// that refreshes the token transparently when needed.
+func NewAuthenticatedClient(storage config.Store) *client.QrypticClient {
+	baseUrl, _ := storage.GetBaseUrl()
+	authToken, _ := storage.GetAuthToken()
+	qrypticClient := client.NewQrypticClient(baseUrl, authToken)
+	qrypticClient.AuthTokenExpiresAt, _ = storage.GetAuthTokenExpiry()
+	qrypticClient.RefreshAuthToken = func(rejectedToken string) (string, error) {
+		authToken, err := RefreshAuthToken(storage, rejectedToken)
+		qrypticClient.AuthTokenExpiresAt, _ = storage.GetAuthTokenExpiry()
+		return authToken, err
+	}
+	return qrypticClient
+}
@@ -3,18 +3,28 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/leetsecure/qryptic-client-cli/internal/config"
 	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/leetsecure/qryptic-client-cli/internal/pkce"
 )
 
 type QrypticClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
 	AuthToken  string
+	// AuthTokenExpiresAt is the expiry the controller gave for AuthToken,
+	// used when the token has no exp claim. Zero when unknown.
+	AuthTokenExpiresAt time.Time
+	// RefreshAuthToken, when set, is called with the current token once it has
+	// expired or was rejected, and returns the token to retry with.
+	RefreshAuthToken func(rejectedToken string) (string, error)
 }
 
 // NewLeetClient creates a new instance of LeetClient.
@@ -44,6 +54,70 @@ func (c *QrypticClient) EmailPasswordLogin(req models.EmailPasswordLoginRequest)
 	return statusCode, &response, nil
 }
 
+func (c *QrypticClient) VerifyMfa(req models.MfaVerifyRequest) (int, *models.AuthResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/auth/mfa/verify", c.BaseURL)
+
+	statusCode, respBody, err := c.doRequest(http.MethodPost, url, req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var response models.AuthResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
+func (c *QrypticClient) SendMfaEmailCode(req models.MfaEmailCodeRequest) (int, error) {
+	url := fmt.Sprintf("%s/api/v1/auth/mfa/email", c.BaseURL)
+
+	statusCode, _, err := c.doRequest(http.MethodPost, url, req)
+	if err != nil {
+		return 0, err
+	}
+	return statusCode, nil
+}
+
+func (c *QrypticClient) GetCurrentUser() (int, *models.CurrentUserResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/auth/me", c.BaseURL)
+
+	statusCode, respBody, err := c.doRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if statusCode != http.StatusOK {
+		return statusCode, nil, nil
+	}
+
+	var response models.CurrentUserResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
+// MachineTokenURL is the token endpoint, also used as the audience of client assertions.
+func (c *QrypticClient) MachineTokenURL() string {
+	return fmt.Sprintf("%s/api/v1/auth/machine/token", c.BaseURL)
+}
+
+func (c *QrypticClient) GetMachineToken(req models.MachineTokenRequest) (int, *models.AuthResponse, error) {
+	statusCode, respBody, err := c.doRequest(http.MethodPost, c.MachineTokenURL(), req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var response models.AuthResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
 func (c *QrypticClient) ControllerHealthCheck() (int, *models.HealthCheckResponse, error) {
 	url := fmt.Sprintf("%s/api/v1/health", c.BaseURL)
 
@@ -92,13 +166,123 @@ func (c *QrypticClient) GetGatewayClient(uuid string) (int, *(models.WGClientCon
 	return statusCode, &clientConfigResponse, nil
 }
 
-func (c *QrypticClient) GetWebSSOToken(codeVerifier, codeChallenge string) (int, *models.AuthResponse, error) {
-	url := fmt.Sprintf("%s/api/v1/auth/google/web/sso/token?code_verifier=%s&code_challenge=%s", c.BaseURL, codeVerifier, codeChallenge)
+func (c *QrypticClient) StartDeviceAuthorization() (int, *models.DeviceAuthorizationResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/auth/device/code", c.BaseURL)
+
+	statusCode, respBody, err := c.doRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var response models.DeviceAuthorizationResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
+func (c *QrypticClient) GetDeviceToken(req models.DeviceTokenRequest) (int, *models.AuthResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/auth/device/token", c.BaseURL)
+
+	statusCode, respBody, err := c.doRequest(http.MethodPost, url, req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var response models.AuthResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
+func (c *QrypticClient) GetJWKS() (int, *models.JWKSResponse, error) {
+	url := fmt.Sprintf("%s/.well-known/jwks.json", c.BaseURL)
+
+	statusCode, respBody, err := c.doRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var response models.JWKSResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
+func (c *QrypticClient) ListLoginProviders() (int, *models.LoginProvidersResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/auth/providers", c.BaseURL)
 
 	statusCode, respBody, err := c.doRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return 0, nil, err
 	}
+	if statusCode != http.StatusOK {
+		return statusCode, nil, nil
+	}
+
+	var response models.LoginProvidersResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
+func (c *QrypticClient) ExchangeSSOCode(exchangePath string, req models.SSOCodeExchangeRequest) (int, *models.AuthResponse, error) {
+	if err := pkce.ValidateVerifier(req.CodeVerifier); err != nil {
+		return 0, nil, err
+	}
+	url := fmt.Sprintf("%s%s", c.BaseURL, exchangePath)
+
+	statusCode, respBody, err := c.doRequest(http.MethodPost, url, req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var response models.AuthResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
+func (c *QrypticClient) GetWebSSOToken(tokenPath, codeVerifier, codeChallenge string) (int, *models.AuthResponse, error) {
+	if err := pkce.ValidateVerifier(codeVerifier); err != nil {
+		return 0, nil, err
+	}
+	url := fmt.Sprintf("%s%s", c.BaseURL, tokenPath)
+
+	// The verifier must not end up in URLs and access logs, there is no
+	// fallback to a query parameter for controllers that only accept GET.
+	statusCode, respBody, err := c.doRequest(http.MethodPost, url, models.SSOTokenRequest{CodeVerifier: codeVerifier, CodeChallenge: codeChallenge})
+	if err != nil {
+		return 0, nil, err
+	}
+	if statusCode == http.StatusMethodNotAllowed {
+		return statusCode, nil, errors.New("the controller does not accept the SSO token request as POST, upgrade the controller to log in with SSO")
+	}
+	var response models.AuthResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return statusCode, &response, nil
+}
+
+func (c *QrypticClient) RefreshToken(req models.RefreshTokenRequest) (int, *models.AuthResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/auth/refresh", c.BaseURL)
+
+	statusCode, respBody, err := c.doRequest(http.MethodPost, url, req)
+	if err != nil {
+		return 0, nil, err
+	}
+
 	var response models.AuthResponse
 	if err := json.Unmarshal(respBody, &response); err != nil {
 		return 0, nil, fmt.Errorf("failed to parse response: %w", err)
@@ -107,16 +291,64 @@ func (c *QrypticClient) GetWebSSOToken(codeVerifier, codeChallenge string) (int,
 	return statusCode, &response, nil
 }
 
+func (c *QrypticClient) RevokeToken(req models.RevokeTokenRequest) (int, error) {
+	url := fmt.Sprintf("%s/api/v1/auth/revoke", c.BaseURL)
+
+	statusCode, _, err := c.doRequest(http.MethodPost, url, req)
+	return statusCode, err
+}
+
+// RevokeGatewayClient deletes the WireGuard peer the controller issued to this device.
+func (c *QrypticClient) RevokeGatewayClient(uuid string) (int, error) {
+	url := fmt.Sprintf("%s/api/v1/gateway/%s/client", c.BaseURL, uuid)
+
+	statusCode, _, err := c.doRequest(http.MethodDelete, url, nil)
+	return statusCode, err
+}
+
 func (c *QrypticClient) doRequest(method, url string, body interface{}) (int, []byte, error) {
-	var reqBody io.Reader
+	var bodyBytes []byte
 	if body != nil {
-		bodyBytes, err := json.Marshal(body)
+		var err error
+		bodyBytes, err = json.Marshal(body)
 		if err != nil {
 			return 0, nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		reqBody = bytes.NewReader(bodyBytes)
 	}
 
+	canRefresh := c.RefreshAuthToken != nil && c.AuthToken != ""
+	if canRefresh && tokenExpired(c.AuthToken, c.AuthTokenExpiresAt) {
+		if err := c.refresh(); err != nil {
+			return 0, nil, err
+		}
+		canRefresh = false
+	}
+
+	statusCode, respBody, err := c.send(method, url, bodyBytes)
+	if err == nil && statusCode == http.StatusUnauthorized && canRefresh {
+		// Retry once with a fresh token; a second 401 is returned to the caller.
+		if refreshErr := c.refresh(); refreshErr != nil {
+			return statusCode, respBody, nil
+		}
+		return c.send(method, url, bodyBytes)
+	}
+	return statusCode, respBody, err
+}
+
+func (c *QrypticClient) refresh() error {
+	authToken, err := c.RefreshAuthToken(c.AuthToken)
+	if err != nil {
+		return fmt.Errorf("failed to refresh auth token: %w", err)
+	}
+	c.AuthToken = authToken
+	return nil
+}
+
+func (c *QrypticClient) send(method, url string, bodyBytes []byte) (int, []byte, error) {
+	var reqBody io.Reader
+	if bodyBytes != nil {
+		reqBody = bytes.NewReader(bodyBytes)
+	}
 	req, err := http.NewRequest(method, url, reqBody)
 	if err != nil {
 		return 0, nil, fmt.Errorf("failed to create request: %w", err)
@@ -143,3 +375,20 @@ func (c *QrypticClient) doRequest(method, url string, body interface{}) (int, []
 	dataBytes, err := io.ReadAll(resp.Body)
 	return resp.StatusCode, dataBytes, err
 }
+
+// tokenExpired reports whether the token's exp claim has passed, or
+// storedExpiry when the token is opaque or has no exp claim. The signature
+// is not checked here, the controller does that on every request.
+func tokenExpired(authToken string, storedExpiry time.Time) bool {
+	expiresAt := storedExpiry
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(authToken, claims); err == nil {
+		if expiryTime, err := claims.GetExpirationTime(); err == nil && expiryTime != nil {
+			expiresAt = expiryTime.Time
+		}
+	}
+	if expiresAt.IsZero() {
+		return false
+	}
+	return time.Now().Add(config.AuthTokenRefreshMargin).After(expiresAt)
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+func signedToken(t *testing.T, expiresAt time.Time) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expiresAt)}).SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenExpired(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name         string
+		token        string
+		storedExpiry time.Time
+		want         bool
+	}{
+		{name: "jwt expired", token: signedToken(t, past), want: true},
+		{name: "jwt valid", token: signedToken(t, future), want: false},
+		{name: "exp claim wins over stored expiry", token: signedToken(t, future), storedExpiry: past, want: false},
+		{name: "opaque without expiry", token: "opaque-token", want: false},
+		{name: "opaque with stored expiry passed", token: "opaque-token", storedExpiry: past, want: true},
+		{name: "opaque with stored expiry ahead", token: "opaque-token", storedExpiry: future, want: false},
+	}
+	for _, tt := range tests {
+		if got := tokenExpired(tt.token, tt.storedExpiry); got != tt.want {
+			t.Errorf("%s: tokenExpired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDoRequestRefreshesExpiredOpaqueToken(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	c := NewQrypticClient(server.URL, "old-token")
+	c.AuthTokenExpiresAt = time.Now().Add(-time.Minute)
+	refreshed := 0
+	c.RefreshAuthToken = func(rejectedToken string) (string, error) {
+		refreshed++
+		return "new-token", nil
+	}
+	if _, _, err := c.doRequest(http.MethodGet, server.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if refreshed != 1 || authorization != "Bearer new-token" {
+		t.Errorf("refreshed %d times, sent %q", refreshed, authorization)
+	}
+}
+
+func TestGetWebSSOTokenSendsVerifierInBody(t *testing.T) {
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	var method, rawQuery string
+	var body models.SSOTokenRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, rawQuery = r.Method, r.URL.RawQuery
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"authToken":"token"}`))
+	}))
+	defer server.Close()
+
+	statusCode, response, err := NewQrypticClient(server.URL, "").GetWebSSOToken("/api/v1/auth/sso/token", verifier, "challenge")
+	if err != nil || statusCode != http.StatusOK || response.AuthToken != "token" {
+		t.Fatalf("status %d, response %+v, err %v", statusCode, response, err)
+	}
+	if method != http.MethodPost || rawQuery != "" {
+		t.Errorf("sent %s with query %q, want a POST without query", method, rawQuery)
+	}
+	if body.CodeVerifier != verifier || body.CodeChallenge != "challenge" {
+		t.Errorf("body %+v", body)
+	}
+}
+
+func TestGetWebSSOTokenRefusesGetOnlyController(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	if _, _, err := NewQrypticClient(server.URL, "").GetWebSSOToken("/token", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", "challenge"); err == nil {
+		t.Error("no error for a controller that only accepts GET")
+	}
+	if requests != 1 {
+		t.Errorf("%d requests, want no retry", requests)
+	}
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const bundleFormat = "qryptic-config-bundle"
+
+var ErrBadPassphrase = errors.New("wrong passphrase or not a qryptic config bundle")
+
+// Bundle is the portable part of the config, for moving to another machine.
+// WireGuard keys and gateway clients are tied to the machine and are left
+// out, only the gateways in use are listed so that they can be registered
+// again. Session and machine tokens are left out as well, the profiles log in
+// again on the new machine. Only API tokens and machine identities travel.
+type Bundle struct {
+	ExportedAt    time.Time       `json:"exportedAt"`
+	ActiveProfile string          `json:"activeProfile"`
+	Profiles      []BundleProfile `json:"profiles"`
+}
+
+type BundleProfile struct {
+	Name string `json:"name"`
+	// Settings are the values of Settings the user chose for the profile.
+	Settings      map[string]interface{} `json:"settings"`
+	AuthForUrl    string                 `json:"authForUrl,omitempty"`
+	AuthTokenType string                 `json:"authTokenType,omitempty"`
+	AuthToken     string                 `json:"authToken,omitempty"`
+	Machine       *MachineIdentity       `json:"machine,omitempty"`
+	Gateways      []string               `json:"gateways,omitempty"`
+}
+
+// sealedBundle is the on-disk form of a Bundle, AES-256-GCM sealed with a
+// key derived from the passphrase.
+type sealedBundle struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// ExportBundle collects every profile of store with its settings and the
+// credentials that may leave the machine.
+func ExportBundle(store Store) (*Bundle, error) {
+	bundle := &Bundle{ExportedAt: time.Now().UTC(), ActiveProfile: store.GetActiveProfile()}
+	for _, name := range store.ListProfiles() {
+		profile := store.ForProfile(name)
+		exported := BundleProfile{Name: name, Settings: map[string]interface{}{}}
+		for _, setting := range Settings {
+			if value, ok := userSetting(profile, setting.Key); ok {
+				if d, isDuration := value.(time.Duration); isDuration {
+					value = d.String()
+				}
+				exported.Settings[setting.Key] = value
+			}
+		}
+		if authToken, exists := profile.GetAuthToken(); exists && profile.GetAuthTokenType() == AuthTokenTypeAPI {
+			exported.AuthForUrl, _ = profile.GetAuthForUrl()
+			exported.AuthToken = authToken
+			exported.AuthTokenType = AuthTokenTypeAPI
+		}
+		if identity, _, exists := profile.GetMachineIdentity(); exists {
+			exported.Machine = &identity
+		}
+		exported.Gateways = profile.GetQrypticClientUuids()
+		bundle.Profiles = append(bundle.Profiles, exported)
+	}
+	return bundle, nil
+}
+
+// userSetting returns the value the user stored for key, ignoring what the
+// environment or the system-wide config would make of it.
+func userSetting(store Store, key string) (interface{}, bool) {
+	if policyStore, ok := store.(*PolicyStore); ok {
+		store = policyStore.Store
+	}
+	value, origin := store.Setting(key)
+	return value, origin.Layer == OriginUser
+}
+
+// ImportResult tells what happened to each profile of an imported bundle.
+type ImportResult struct {
+	Profile string
+	Skipped bool
+	// Warnings are settings that could not be restored, such as those
+	// enforced by the system policy.
+	Warnings []string
+}
+
+// ImportBundle restores the profiles of bundle into store. Profiles that
+// already exist and are logged in are skipped unless overwrite is set. The
+// machine identity is restored without its device id, it has to be
+// registered again from this machine. Only API tokens are restored, any
+// other token in the bundle is ignored.
+func ImportBundle(store Store, bundle *Bundle, overwrite bool) ([]ImportResult, error) {
+	results := []ImportResult{}
+	for _, imported := range bundle.Profiles {
+		result := ImportResult{Profile: imported.Name}
+		if !store.ProfileExists(imported.Name) {
+			if err := store.AddProfile(imported.Name, ""); err != nil {
+				return results, err
+			}
+		} else if _, loggedIn := store.ForProfile(imported.Name).GetAuthToken(); loggedIn && !overwrite {
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+		profile := store.ForProfile(imported.Name)
+
+		keys := make([]string, 0, len(imported.Settings))
+		for key := range imported.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, known := LookupSetting(key); !known {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("unknown setting %s ignored", key))
+				continue
+			}
+			if err := profile.SetSetting(key, imported.Settings[key]); err != nil {
+				result.Warnings = append(result.Warnings, err.Error())
+			}
+		}
+
+		if err := profile.ClearProfile(); err != nil {
+			return results, err
+		}
+		if imported.AuthToken != "" && imported.AuthTokenType == AuthTokenTypeAPI {
+			if err := profile.SetAPIToken(imported.AuthToken); err != nil {
+				return results, err
+			}
+			if err := profile.SetAuthForUrl(imported.AuthForUrl); err != nil {
+				return results, err
+			}
+		}
+		if imported.Machine != nil {
+			if err := profile.SetMachineIdentity(*imported.Machine, ""); err != nil {
+				return results, err
+			}
+		}
+		results = append(results, result)
+	}
+	if bundle.ActiveProfile != "" && store.ProfileExists(bundle.ActiveProfile) {
+		if err := store.UseProfile(bundle.ActiveProfile); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// SealBundle encrypts bundle with passphrase.
+func SealBundle(bundle *Bundle, passphrase string) ([]byte, error) {
+	plaintext, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(sealedBundle{
+		Format:  bundleFormat,
+		Version: 1,
+		Salt:    salt,
+		Nonce:   nonce,
+		Data:    gcm.Seal(nil, nonce, plaintext, []byte(bundleFormat)),
+	}, "", "  ")
+}
+
+// OpenBundle decrypts a bundle written by SealBundle.
+func OpenBundle(data []byte, passphrase string) (*Bundle, error) {
+	var sealed sealedBundle
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.Format != bundleFormat {
+		return nil, ErrBadPassphrase
+	}
+	if sealed.Version != 1 {
+		return nil, fmt.Errorf("unsupported config bundle version %d, upgrade qryptic to import it", sealed.Version)
+	}
+	key, err := deriveKey(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != gcm.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+	plaintext, err := gcm.Open(nil, sealed.Nonce, sealed.Data, []byte(bundleFormat))
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	bundle := &Bundle{}
+	if err := json.Unmarshal(plaintext, bundle); err != nil {
+		return nil, fmt.Errorf("invalid config bundle: %w", err)
+	}
+	for _, profile := range bundle.Profiles {
+		if !profileNamePattern.MatchString(profile.Name) {
+			return nil, fmt.Errorf("invalid profile name %q in config bundle", profile.Name)
+		}
+	}
+	return bundle, nil
+}
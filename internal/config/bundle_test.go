@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportTestStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	store := NewMemoryStore()
+	must(t, store.SetBaseUrl("https://controller.example"))
+	must(t, store.SetAuthTokens("session-token", "session-refresh", time.Time{}))
+	must(t, store.SetAuthForUrl("https://controller.example"))
+	must(t, store.SetQrypticClient("gw-1", testGateway("private-key")))
+	must(t, store.SetSetting(SplitDNSDomains, []string{"corp.example"}))
+
+	must(t, store.AddProfile("work", "https://work.example"))
+	work := store.ForProfile("work")
+	must(t, work.SetAPIToken("api-token"))
+	must(t, work.SetAuthForUrl("https://work.example"))
+	must(t, work.SetSetting(KillSwitchEnabled, true))
+
+	must(t, store.AddProfile("ci", "https://ci.example"))
+	ci := store.ForProfile("ci")
+	must(t, ci.SetMachineIdentity(MachineIdentity{ClientId: "machine-1", ClientSecret: "machine-secret"}, "old-device"))
+	must(t, ci.SetMachineToken("machine-token", time.Time{}))
+	must(t, store.UseProfile("work"))
+	return store
+}
+
+func TestExportBundleLeavesSessionTokens(t *testing.T) {
+	bundle, err := ExportBundle(exportTestStore(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"session-token", "session-refresh", "machine-token", "private-key", "old-device"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("bundle contains %s:\n%s", secret, data)
+		}
+	}
+	for _, want := range []string{"api-token", "machine-secret", "gw-1", "corp.example"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("bundle lacks %s:\n%s", want, data)
+		}
+	}
+}
+
+func TestSealOpenBundle(t *testing.T) {
+	bundle, err := ExportBundle(exportTestStore(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealBundle(bundle, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), "api-token") {
+		t.Fatal("sealed bundle holds the token in plain text")
+	}
+
+	opened, err := OpenBundle(sealed, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opened.Profiles) != 3 || opened.ActiveProfile != "work" {
+		t.Errorf("opened %+v", opened)
+	}
+
+	if _, err := OpenBundle(sealed, "wrong horse"); !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("wrong passphrase: %v", err)
+	}
+	if _, err := OpenBundle([]byte("not json"), "correct horse"); !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("garbage: %v", err)
+	}
+
+	var tampered sealedBundle
+	must(t, json.Unmarshal(sealed, &tampered))
+	tampered.Data[0] ^= 0xff
+	data, _ := json.Marshal(tampered)
+	if _, err := OpenBundle(data, "correct horse"); !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("tampered data: %v", err)
+	}
+
+	tampered.Version = 2
+	data, _ = json.Marshal(tampered)
+	if _, err := OpenBundle(data, "correct horse"); err == nil || errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("newer version: %v", err)
+	}
+
+	bundle.Profiles[0].Name = "../evil"
+	sealed, err = SealBundle(bundle, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBundle(sealed, "correct horse"); err == nil {
+		t.Error("invalid profile name accepted")
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	bundle, err := ExportBundle(exportTestStore(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	results, err := ImportBundle(store, bundle, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Skipped || len(result.Warnings) > 0 {
+			t.Errorf("%+v", result)
+		}
+	}
+
+	if _, loggedIn := store.GetAuthToken(); loggedIn {
+		t.Error("session profile logged in without logging in again")
+	}
+	if domains := store.GetSplitDNSDomains(); len(domains) != 1 || domains[0] != "corp.example" {
+		t.Errorf("settings not restored: %v", domains)
+	}
+	if baseUrl, _ := store.GetBaseUrl(); baseUrl != "https://controller.example" {
+		t.Errorf("base url %q", baseUrl)
+	}
+	if uuids := store.GetQrypticClientUuids(); len(uuids) != 0 {
+		t.Errorf("gateway clients imported: %v", uuids)
+	}
+
+	work := store.ForProfile("work")
+	if token, _ := work.GetAuthToken(); token != "api-token" || work.GetAuthTokenType() != AuthTokenTypeAPI {
+		t.Errorf("API token %q of type %s", token, work.GetAuthTokenType())
+	}
+	if enabled, _ := work.GetKillSwitch(); !enabled {
+		t.Error("work settings not restored")
+	}
+
+	ci := store.ForProfile("ci")
+	identity, deviceId, exists := ci.GetMachineIdentity()
+	if !exists || identity.ClientSecret != "machine-secret" || deviceId != "" {
+		t.Errorf("machine identity %+v, device %q", identity, deviceId)
+	}
+	if _, loggedIn := ci.GetAuthToken(); loggedIn {
+		t.Error("machine token imported, it has to be requested from this machine")
+	}
+	if active := store.GetActiveProfile(); active != "work" {
+		t.Errorf("active profile %q", active)
+	}
+
+	// A logged in profile is only replaced with overwrite
+	must(t, work.SetAPIToken("local-token"))
+	results, err = ImportBundle(store, bundle, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Skipped != (result.Profile == "work") {
+			t.Errorf("%s: skipped %v, only the logged in profile should be", result.Profile, result.Skipped)
+		}
+	}
+	if token, _ := work.GetAuthToken(); token != "local-token" {
+		t.Errorf("skipped profile changed, token %q", token)
+	}
+	if _, err := ImportBundle(store, bundle, true); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := work.GetAuthToken(); token != "api-token" {
+		t.Errorf("overwrite kept token %q", token)
+	}
+}
+
+func TestImportBundlePolicy(t *testing.T) {
+	bundle, err := ExportBundle(exportTestStore(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	system := writeSystemConfig(t, "policy:\n  killSwitch:\n    enabled: false\n")
+	results, err := ImportBundle(NewPolicyStore(NewMemoryStore(), system), bundle, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		warned := len(result.Warnings) == 1 && strings.Contains(result.Warnings[0], KillSwitchEnabled)
+		if warned != (result.Profile == "work") {
+			t.Errorf("%s: warnings %v", result.Profile, result.Warnings)
+		}
+	}
+}
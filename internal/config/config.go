@@ -5,11 +5,73 @@ import "time"
 var BaseUrl = "baseUrl"
 var AuthForUrl = "authForUrl"
 var AuthToken = "authToken"
+var RefreshToken = "refreshToken"
+var AuthTokenType = "authTokenType"
+var AuthTokenExpiresAt = "authTokenExpiresAt"
 var ConnectedToGateway = "connectedToGateway"
 var ConnectedToGatewayUuid = "connectedToGateway.uuid"
 var ConnectedToGatewayName = "connectedToGateway.name"
+var ConnectedToGatewayProfile = "connectedToGateway.profile"
+var ActiveProfile = "activeProfile"
+var ProfilesKey = "profiles"
+var DefaultProfile = "default"
+var GatewaysKey = "gateways"
+var SchemaVersion = "schemaVersion"
+var CurrentSchemaVersion = 2
 
 var ConfigFileName = ".qryptic"
 var ConfigFileType = "yaml"
+var SystemConfigPath = "/etc/qryptic/config.yaml"
+var PolicySection = "policy"
+var PolicyLoginMethods = "loginMethods"
+var PolicyAllowRouteOverrides = "allowRouteOverrides"
 var QrypticClientRefetchTimeGap = 30 * time.Minute
 var IsWireguardSetupCompleted = "isWireguardSetupCompleted"
+var SplitDNSDomains = "splitDns.domains"
+var SplitDNSListenAddr = "splitDns.listenAddr"
+var DefaultSplitDNSListenAddr = "127.0.0.1:53"
+var SplitDNSMinRouteTTL = 30 * time.Second
+var KillSwitchEnabled = "killSwitch.enabled"
+var KillSwitchAllowLAN = "killSwitch.allowLan"
+var RoutesExclude = "routes.exclude"
+var AuthIssuer = "auth.issuer"
+var AuthAudience = "auth.audience"
+var AuthClockSkew = "auth.clockSkew"
+var AuthSSOTimeout = "auth.ssoTimeout"
+var DefaultAuthAudience = "qryptic-client"
+var JWTClockSkew = 60 * time.Second
+var JWKSCacheTTL = 1 * time.Hour
+var SSOLoginTimeout = 5 * time.Minute
+var AuthTokenRefreshMargin = 30 * time.Second
+var AuthTokenExpiryWarning = 1 * time.Hour
+var LockTimeout = 30 * time.Second
+
+// Token types stored under AuthTokenType. API tokens are opaque to the CLI
+// and can only be checked by the controller.
+var AuthTokenTypeSession = "session"
+var AuthTokenTypeAPI = "api"
+var AuthTokenTypeMachine = "machine"
+
+var MachineSection = "machine"
+var MachineClientId = "machine.clientId"
+var MachineClientSecret = "machine.clientSecret"
+var MachinePrivateKeyPath = "machine.privateKeyPath"
+var MachineDeviceId = "machine.deviceId"
+
+var EnvEmail = "QRYPTIC_EMAIL"
+var EnvPassword = "QRYPTIC_PASSWORD"
+var EnvToken = "QRYPTIC_TOKEN"
+var EnvNoPrompt = "QRYPTIC_NO_PROMPT"
+var EnvClientId = "QRYPTIC_CLIENT_ID"
+var EnvClientSecret = "QRYPTIC_CLIENT_SECRET"
+var EnvPrivateKeyFile = "QRYPTIC_PRIVATE_KEY_FILE"
+
+var SecretsBackend = "secrets.backend"
+var SecretsBackendAuto = "auto"
+var SecretsBackendKeyring = "keyring"
+var SecretsBackendFile = "file"
+var SecretsFileName = ".qryptic-secrets"
+var KeyringService = "qryptic"
+var EnvSecretsPassphrase = "QRYPTIC_SECRETS_PASSPHRASE"
+var EnvBundlePassphrase = "QRYPTIC_BUNDLE_PASSPHRASE"
+var MinBundlePassphraseLength = 8
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/leetsecure/qryptic-client-cli/internal/logger"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// WriteFileAtomic replaces path with data so that readers see either the old
+// or the new content, never a partial write, even across a crash.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	// Persist the rename itself.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// fileState is shared by every FileStore opened on the same config file.
+type fileState struct {
+	mu    sync.Mutex
+	held  int
+	flock *flock.Flock
+	// Command line flags overriding settings, keyed by setting.
+	flags map[string]*pflag.Flag
+	// Size and modification time of the file when it was last read or written.
+	size    int64
+	modTime time.Time
+}
+
+// Lock takes an exclusive advisory lock shared by all CLI processes and
+// returns the function that releases it. The lock is re-entrant within the
+// process, so writes made while holding it do not deadlock.
+func (s *FileStore) Lock() (func(), error) {
+	state := s.state
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.held == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), LockTimeout)
+		defer cancel()
+		locked, err := state.flock.TryLockContext(ctx, 100*time.Millisecond)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock config: %w", err)
+		}
+		if !locked {
+			return nil, fmt.Errorf("timed out waiting for the config lock")
+		}
+	}
+	state.held++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			state.held--
+			if state.held == 0 {
+				state.flock.Unlock()
+			}
+		})
+	}, nil
+}
+
+// Reload re-reads the config file to pick up changes made by other processes.
+func (s *FileStore) Reload() error {
+	if err := s.vip.ReadInConfig(); err != nil {
+		return err
+	}
+	s.recordFileState()
+	return nil
+}
+
+// reloadIfChanged re-reads the config file when another process replaced it
+// since it was last read or written.
+func (s *FileStore) reloadIfChanged() error {
+	info, err := os.Stat(s.vip.ConfigFileUsed())
+	if err != nil {
+		return err
+	}
+	s.state.mu.Lock()
+	changed := info.Size() != s.state.size || !info.ModTime().Equal(s.state.modTime)
+	s.state.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return s.Reload()
+}
+
+func (s *FileStore) recordFileState() {
+	info, err := os.Stat(s.vip.ConfigFileUsed())
+	if err != nil {
+		return
+	}
+	s.state.mu.Lock()
+	s.state.size, s.state.modTime = info.Size(), info.ModTime()
+	s.state.mu.Unlock()
+}
+
+// v returns the settings, re-read first if the file changed underneath.
+func (s *FileStore) v() *viper.Viper {
+	if err := s.reloadIfChanged(); err != nil {
+		logger.Default().Warn("failed to reload config", "error", err)
+	}
+	return s.vip
+}
+
+// write stores values under their keys in the config file, a nil value
+// removes the key. It runs under the lock and re-reads the file first so that
+// changes made by other processes since it was loaded are kept. Values are
+// not kept as viper overrides, which would hide later changes to the file.
+func (s *FileStore) write(values map[string]interface{}) error {
+	unlock, err := s.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.reloadIfChanged(); err != nil {
+		return err
+	}
+	settings := s.vip.AllSettings()
+	for key, value := range values {
+		setNested(settings, key, value)
+	}
+	if err := s.writeSettings(settings); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// setNested sets the dotted key in settings, creating the sections on the
+// way, or removes it when value is nil.
+func setNested(settings map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(strings.ToLower(key), ".")
+	section := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := section[part].(map[string]interface{})
+		if !ok {
+			if value == nil {
+				return
+			}
+			next = map[string]interface{}{}
+			section[part] = next
+		}
+		section = next
+	}
+	if value == nil {
+		delete(section, parts[len(parts)-1])
+		return
+	}
+	section[parts[len(parts)-1]] = value
+}
+
+func (s *FileStore) writeSettings(settings map[string]interface{}) error {
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(s.vip.ConfigFileUsed(), data, 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	s.recordFileState()
+	return nil
+}
@@ -0,0 +1,407 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/spf13/pflag"
+)
+
+// memoryProfile is everything a MemoryStore keeps per profile.
+type memoryProfile struct {
+	// settings holds the values of Settings that were set.
+	settings        map[string]interface{}
+	authForUrl      string
+	authToken       string
+	refreshToken    string
+	authTokenType   string
+	authTokenExpiry time.Time
+	machine         MachineIdentity
+	machineDeviceId string
+	// Keyed by lower-cased UUID, like the FileStore.
+	gateways map[string]memoryGateway
+}
+
+type memoryGateway struct {
+	uuid         string
+	clientConfig models.WGClientConfig
+}
+
+type memoryData struct {
+	mu               sync.Mutex
+	activeProfile    string
+	profiles         map[string]*memoryProfile
+	connectedUuid    string
+	connectedName    string
+	connectedProfile string
+	wireguardSetup   bool
+}
+
+// MemoryStore is a Store that keeps everything in memory, for tests and for
+// commands that must not touch the user's config.
+type MemoryStore struct {
+	data    *memoryData
+	profile string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: &memoryData{
+			activeProfile: DefaultProfile,
+			profiles:      map[string]*memoryProfile{DefaultProfile: newMemoryProfile("")},
+		},
+		profile: DefaultProfile,
+	}
+}
+
+func newMemoryProfile(baseUrl string) *memoryProfile {
+	p := &memoryProfile{settings: map[string]interface{}{}, gateways: map[string]memoryGateway{}}
+	if baseUrl != "" {
+		p.settings[BaseUrl] = baseUrl
+	}
+	return p
+}
+
+// with runs fn on the store's profile under the data lock.
+func (m *MemoryStore) with(fn func(p *memoryProfile)) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	p, ok := m.data.profiles[m.profile]
+	if !ok {
+		p = newMemoryProfile("")
+		m.data.profiles[m.profile] = p
+	}
+	fn(p)
+}
+
+func (m *MemoryStore) Profile() string {
+	return m.profile
+}
+
+func (m *MemoryStore) ForProfile(profile string) Store {
+	return &MemoryStore{data: m.data, profile: profile}
+}
+
+func (m *MemoryStore) GetActiveProfile() string {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	return m.data.activeProfile
+}
+
+func (m *MemoryStore) ListProfiles() []string {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	profiles := []string{}
+	for name := range m.data.profiles {
+		if name != DefaultProfile {
+			profiles = append(profiles, name)
+		}
+	}
+	sort.Strings(profiles)
+	return append([]string{DefaultProfile}, profiles...)
+}
+
+func (m *MemoryStore) ProfileExists(name string) bool {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	_, exists := m.data.profiles[name]
+	return exists
+}
+
+func (m *MemoryStore) AddProfile(name, baseUrl string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, use lower case letters, digits, - and _", name)
+	}
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	if _, exists := m.data.profiles[name]; exists {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	m.data.profiles[name] = newMemoryProfile(baseUrl)
+	return nil
+}
+
+func (m *MemoryStore) UseProfile(name string) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	if _, exists := m.data.profiles[name]; !exists {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	m.data.activeProfile = name
+	return nil
+}
+
+func (m *MemoryStore) RemoveProfile(name string) error {
+	if name == DefaultProfile {
+		return fmt.Errorf("the %s profile cannot be removed", DefaultProfile)
+	}
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	if _, exists := m.data.profiles[name]; !exists {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	delete(m.data.profiles, name)
+	if m.data.activeProfile == name {
+		m.data.activeProfile = DefaultProfile
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetBaseUrl() (string, bool) {
+	baseUrl := StringSetting(m, BaseUrl)
+	return baseUrl, baseUrl != ""
+}
+
+func (m *MemoryStore) SetBaseUrl(baseUrl string) error {
+	m.with(func(p *memoryProfile) { p.settings[BaseUrl] = baseUrl })
+	return nil
+}
+
+func (m *MemoryStore) GetAuthForUrl() (authForUrl string, exists bool) {
+	m.with(func(p *memoryProfile) { authForUrl = p.authForUrl })
+	return authForUrl, authForUrl != ""
+}
+
+func (m *MemoryStore) SetAuthForUrl(authForUrl string) error {
+	m.with(func(p *memoryProfile) { p.authForUrl = authForUrl })
+	return nil
+}
+
+func (m *MemoryStore) GetAuthIssuer() string {
+	return StringSetting(m, AuthIssuer)
+}
+
+func (m *MemoryStore) GetAuthAudience() string {
+	return StringSetting(m, AuthAudience)
+}
+
+func (m *MemoryStore) GetAuthToken() (authToken string, exists bool) {
+	m.with(func(p *memoryProfile) { authToken = p.authToken })
+	return authToken, authToken != ""
+}
+
+func (m *MemoryStore) SetAuthToken(authToken string) error {
+	m.with(func(p *memoryProfile) { p.authToken = authToken })
+	return nil
+}
+
+func (m *MemoryStore) GetRefreshToken() (refreshToken string, exists bool) {
+	m.with(func(p *memoryProfile) { refreshToken = p.refreshToken })
+	return refreshToken, refreshToken != ""
+}
+
+func (m *MemoryStore) setTokens(authToken, refreshToken, authTokenType string, expiresAt time.Time) error {
+	m.with(func(p *memoryProfile) {
+		p.authToken, p.refreshToken, p.authTokenType, p.authTokenExpiry = authToken, refreshToken, authTokenType, expiresAt
+	})
+	return nil
+}
+
+func (m *MemoryStore) SetAuthTokens(authToken, refreshToken string, expiresAt time.Time) error {
+	return m.setTokens(authToken, refreshToken, AuthTokenTypeSession, expiresAt)
+}
+
+func (m *MemoryStore) SetAPIToken(apiToken string) error {
+	return m.setTokens(apiToken, "", AuthTokenTypeAPI, time.Time{})
+}
+
+func (m *MemoryStore) SetMachineToken(authToken string, expiresAt time.Time) error {
+	return m.setTokens(authToken, "", AuthTokenTypeMachine, expiresAt)
+}
+
+func (m *MemoryStore) GetAuthTokenExpiry() (expiresAt time.Time, exists bool) {
+	m.with(func(p *memoryProfile) { expiresAt = p.authTokenExpiry })
+	return expiresAt, !expiresAt.IsZero()
+}
+
+func (m *MemoryStore) GetAuthTokenType() (authTokenType string) {
+	m.with(func(p *memoryProfile) { authTokenType = p.authTokenType })
+	if authTokenType == "" {
+		return AuthTokenTypeSession
+	}
+	return authTokenType
+}
+
+func (m *MemoryStore) ClearAuthToken() error {
+	m.with(func(p *memoryProfile) {
+		p.authToken, p.refreshToken, p.authForUrl, p.authTokenExpiry = "", "", "", time.Time{}
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetMachineIdentity() (identity MachineIdentity, deviceId string, exists bool) {
+	m.with(func(p *memoryProfile) { identity, deviceId = p.machine, p.machineDeviceId })
+	if identity.ClientId == "" {
+		return identity, "", false
+	}
+	return identity, deviceId, true
+}
+
+func (m *MemoryStore) SetMachineIdentity(identity MachineIdentity, deviceId string) error {
+	m.with(func(p *memoryProfile) { p.machine, p.machineDeviceId = identity, deviceId })
+	return nil
+}
+
+func (m *MemoryStore) GetQrypticClientUuids() []string {
+	uuids := []string{}
+	m.with(func(p *memoryProfile) {
+		for _, gateway := range p.gateways {
+			uuids = append(uuids, gateway.uuid)
+		}
+	})
+	sort.Strings(uuids)
+	return uuids
+}
+
+func (m *MemoryStore) GetQrypticClient(uuid string) (clientConfig models.WGClientConfig, err error) {
+	m.with(func(p *memoryProfile) { clientConfig = p.gateways[strings.ToLower(uuid)].clientConfig })
+	return clientConfig, nil
+}
+
+func (m *MemoryStore) SetQrypticClient(uuid string, clientConfig models.WGClientConfig) error {
+	m.with(func(p *memoryProfile) {
+		p.gateways[strings.ToLower(uuid)] = memoryGateway{uuid: uuid, clientConfig: clientConfig}
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetConnectedToGateway() (string, string, bool) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	if m.data.connectedUuid == "" || m.data.connectedName == "" {
+		return "", "", false
+	}
+	return m.data.connectedUuid, m.data.connectedName, true
+}
+
+func (m *MemoryStore) SetConnectedToGateway(uuid, name string) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	m.data.connectedUuid, m.data.connectedName, m.data.connectedProfile = uuid, name, m.profile
+	return nil
+}
+
+func (m *MemoryStore) GetConnectedProfile() string {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	if m.data.connectedProfile == "" {
+		return DefaultProfile
+	}
+	return m.data.connectedProfile
+}
+
+func (m *MemoryStore) ClearConnectedToGateway() error {
+	return m.SetConnectedToGateway("", "")
+}
+
+func (m *MemoryStore) GetWireguardSetup() bool {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	return m.data.wireguardSetup
+}
+
+func (m *MemoryStore) SetWireguardSetup(isWireguardSetupCompleted bool) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+	m.data.wireguardSetup = isWireguardSetupCompleted
+	return nil
+}
+
+func (m *MemoryStore) GetSplitDNSDomains() []string {
+	return StringListSetting(m, SplitDNSDomains)
+}
+
+func (m *MemoryStore) GetSplitDNSListenAddr() string {
+	return StringSetting(m, SplitDNSListenAddr)
+}
+
+func (m *MemoryStore) GetKillSwitch() (bool, bool) {
+	return BoolSetting(m, KillSwitchEnabled), BoolSetting(m, KillSwitchAllowLAN)
+}
+
+func (m *MemoryStore) Setting(key string) (value interface{}, origin Origin) {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return nil, Origin{}
+	}
+	m.with(func(p *memoryProfile) {
+		var set bool
+		if value, set = p.settings[setting.Key]; set && value != "" {
+			origin = Origin{Layer: OriginUser}
+		} else {
+			value, origin = setting.Default, Origin{Layer: OriginDefault}
+		}
+	})
+	return value, origin
+}
+
+func (m *MemoryStore) SetSetting(key string, value interface{}) error {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	value, err := setting.Normalize(value)
+	if err != nil {
+		return err
+	}
+	m.with(func(p *memoryProfile) { p.settings[setting.Key] = value })
+	return nil
+}
+
+func (m *MemoryStore) UnsetSetting(key string) error {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	m.with(func(p *memoryProfile) { delete(p.settings, setting.Key) })
+	return nil
+}
+
+func (m *MemoryStore) ConfigFile() string {
+	return ""
+}
+
+// Policy enforces nothing.
+func (m *MemoryStore) Policy() *Policy {
+	return newPolicy()
+}
+
+func (m *MemoryStore) ClearSecrets() error {
+	m.with(func(p *memoryProfile) {
+		p.authToken, p.refreshToken, p.machine.ClientSecret = "", "", ""
+		for key, gateway := range p.gateways {
+			gateway.clientConfig.WGClientInterfaceConfig.ClientPrivateKey = ""
+			gateway.clientConfig.WGClientPeerConfig.PresharedKey = ""
+			p.gateways[key] = gateway
+		}
+	})
+	return nil
+}
+
+func (m *MemoryStore) ClearProfile() error {
+	m.with(func(p *memoryProfile) {
+		p.authToken, p.refreshToken, p.authTokenType, p.authForUrl = "", "", "", ""
+		p.authTokenExpiry = time.Time{}
+		p.machine, p.machineDeviceId = MachineIdentity{}, ""
+		p.gateways = map[string]memoryGateway{}
+	})
+	return nil
+}
+
+// Lock is a no-op, a MemoryStore is not shared with other processes.
+func (m *MemoryStore) Lock() (func(), error) {
+	return func() {}, nil
+}
+
+func (m *MemoryStore) Reload() error {
+	return nil
+}
+
+// BindPFlag is a no-op, flags only override settings read from a file.
+func (m *MemoryStore) BindPFlag(key string, flag *pflag.Flag) error {
+	return nil
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// migration upgrades the raw settings of a config file by one schema version.
+type migration func(settings map[string]interface{}, secrets SecretStore) error
+
+// migrations[i] upgrades schema version i+1 to i+2. Files written before
+// schemaVersion existed are version 1.
+var migrations = []migration{
+	migrateV1ToV2,
+}
+
+// migrateConfig upgrades the config file at path in place and refuses files
+// written by a newer version of the CLI. The original is kept in a backup,
+// whose path is returned, with the credentials that moved to the secret
+// store scrubbed out of it.
+func migrateConfig(path string, secrets SecretStore) (migrated bool, backupPath string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, "", err
+	}
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return false, "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+
+	version := 1
+	if len(settings) == 0 {
+		version = CurrentSchemaVersion
+	} else if raw, exists := settings[lowerSchemaVersion]; exists {
+		v, ok := raw.(int)
+		if !ok {
+			return false, "", fmt.Errorf("%s has an invalid %s %v", path, SchemaVersion, raw)
+		}
+		version = v
+	}
+	if version > CurrentSchemaVersion {
+		return false, "", fmt.Errorf("%s uses config schema version %d, this qryptic supports up to version %d. Upgrade qryptic to use it", path, version, CurrentSchemaVersion)
+	}
+	if version < 1 {
+		return false, "", fmt.Errorf("%s has an invalid %s %d", path, SchemaVersion, version)
+	}
+	if version == CurrentSchemaVersion && len(settings) > 0 {
+		return false, "", nil
+	}
+
+	original, fromVersion := len(settings) > 0, version
+	for ; version < CurrentSchemaVersion; version++ {
+		if err := migrations[version-1](settings, secrets); err != nil {
+			return false, "", fmt.Errorf("failed to migrate %s from schema version %d: %w", path, version, err)
+		}
+	}
+	settings[lowerSchemaVersion] = CurrentSchemaVersion
+
+	out, err := yaml.Marshal(settings)
+	if err != nil {
+		return false, "", err
+	}
+	if !original {
+		return true, "", WriteFileAtomic(path, out, 0600)
+	}
+
+	backup, err := scrubbedBackup(data)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	backupPath = fmt.Sprintf("%s.v%d.bak", path, fromVersion)
+	if err := WriteFileAtomic(backupPath, backup, 0600); err != nil {
+		return false, "", fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	if err := WriteFileAtomic(path, out, 0600); err != nil {
+		// The original is still in place
+		os.Remove(backupPath)
+		return false, "", err
+	}
+	if err := verifyMigratedConfig(path, out); err != nil {
+		return false, backupPath, fmt.Errorf("%w, the original is kept in %s", err, backupPath)
+	}
+	return true, backupPath, nil
+}
+
+// credentialKeys are the keys that held plain text credentials before they
+// moved to the secret store.
+var credentialKeys = map[string]bool{
+	"authtoken":        true,
+	"refreshtoken":     true,
+	"clientsecret":     true,
+	"clientprivatekey": true,
+	"presharedkey":     true,
+}
+
+// scrubbedBackup returns the original config with every credential removed,
+// they are in the secret store by the time the backup is written.
+func scrubbedBackup(data []byte) ([]byte, error) {
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	scrubCredentials(settings)
+	return yaml.Marshal(settings)
+}
+
+func scrubCredentials(section map[string]interface{}) {
+	for key, value := range section {
+		if credentialKeys[strings.ToLower(key)] {
+			delete(section, key)
+			continue
+		}
+		if child, ok := value.(map[string]interface{}); ok {
+			scrubCredentials(child)
+		}
+	}
+}
+
+// verifyMigratedConfig reads path back and checks it holds what was written.
+func verifyMigratedConfig(path string, written []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read back %s: %w", path, err)
+	}
+	settings := map[string]interface{}{}
+	if !bytes.Equal(data, written) || yaml.Unmarshal(data, &settings) != nil || settings[lowerSchemaVersion] != CurrentSchemaVersion {
+		return fmt.Errorf("migrated %s does not read back as written", path)
+	}
+	return nil
+}
+
+// viper lower-cases every key it writes.
+var lowerSchemaVersion = "schemaversion"
+
+// globalSettings are the version 1 top-level keys that are not per profile.
+var globalSettings = map[string]bool{
+	"activeprofile":             true,
+	"connectedtogateway":        true,
+	"iswireguardsetupcompleted": true,
+	"secrets":                   true,
+}
+
+// profileSettings are the version 1 keys of a profile that are not cached
+// gateway clients.
+var profileSettings = map[string]bool{
+	"baseurl":       true,
+	"authforurl":    true,
+	"authtoken":     true,
+	"refreshtoken":  true,
+	"authtokentype": true,
+	"splitdns":      true,
+	"killswitch":    true,
+	"auth":          true,
+	"machine":       true,
+}
+
+// migrateV1ToV2 moves the default profile's settings from the top level into
+// profiles.default, gateway clients keyed by bare UUID into
+// profiles.<name>.gateways, and any credentials still in plain text into the
+// secret store.
+func migrateV1ToV2(settings map[string]interface{}, secrets SecretStore) error {
+	profiles, _ := settings[ProfilesKey].(map[string]interface{})
+	if profiles == nil {
+		profiles = map[string]interface{}{}
+	}
+	defaultProfile := map[string]interface{}{}
+	for key, value := range settings {
+		if globalSettings[key] || key == ProfilesKey {
+			continue
+		}
+		defaultProfile[key] = value
+		delete(settings, key)
+	}
+	profiles[DefaultProfile] = defaultProfile
+	settings[ProfilesKey] = profiles
+
+	for name, raw := range profiles {
+		profile, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("profile %s is not a section", name)
+		}
+		// Version 1 kept the default profile's secrets under bare names.
+		oldPrefix := ProfilesKey + "." + name + "."
+		if name == DefaultProfile {
+			oldPrefix = ""
+		}
+		newPrefix := ProfilesKey + "." + name + "."
+
+		for key, secretKey := range map[string]string{"authtoken": AuthToken, "refreshtoken": RefreshToken} {
+			if err := moveSecret(secrets, oldPrefix+secretKey, newPrefix+secretKey, profile[key]); err != nil {
+				return err
+			}
+			delete(profile, key)
+		}
+		if machine, ok := profile["machine"].(map[string]interface{}); ok {
+			if err := moveSecret(secrets, oldPrefix+MachineClientSecret, newPrefix+MachineClientSecret, machine["clientsecret"]); err != nil {
+				return err
+			}
+			delete(machine, "clientsecret")
+		}
+
+		gateways := map[string]interface{}{}
+		for key, value := range profile {
+			if profileSettings[key] {
+				continue
+			}
+			gateway, ok := value.(map[string]interface{})
+			if !ok || (gateway["wgclientinterfaceconfig"] == nil && gateway["clientuuid"] == nil) {
+				continue
+			}
+			// Keyed like FileStore.gatewayKey, with the UUID as issued kept.
+			gateway["gatewayuuid"] = key
+			gateways[strings.ToLower(key)] = gateway
+			delete(profile, key)
+
+			var privateKey, presharedKey interface{}
+			if iface, ok := gateway["wgclientinterfaceconfig"].(map[string]interface{}); ok {
+				privateKey = iface["clientprivatekey"]
+				iface["clientprivatekey"] = ""
+			}
+			if peer, ok := gateway["wgclientpeerconfig"].(map[string]interface{}); ok {
+				presharedKey = peer["presharedkey"]
+				peer["presharedkey"] = ""
+			}
+			oldGateway := oldPrefix + "gateway." + key + "."
+			newGateway := newPrefix + GatewaysKey + "." + strings.ToLower(key) + "."
+			if err := moveSecret(secrets, oldGateway+"privateKey", newGateway+"privateKey", privateKey); err != nil {
+				return err
+			}
+			if err := moveSecret(secrets, oldGateway+"presharedKey", newGateway+"presharedKey", presharedKey); err != nil {
+				return err
+			}
+		}
+		profile[GatewaysKey] = gateways
+	}
+	return nil
+}
+
+// moveSecret stores plaintext under newKey when it is set and otherwise
+// renames the secret stored under oldKey.
+func moveSecret(secrets SecretStore, oldKey, newKey string, plaintext interface{}) error {
+	value, _ := plaintext.(string)
+	if value == "" {
+		stored, err := secrets.Get(oldKey)
+		if errors.Is(err, ErrSecretNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value = stored
+	}
+	if err := secrets.Set(newKey, value); err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	return secrets.Delete(oldKey)
+}
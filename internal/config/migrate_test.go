@@ -0,0 +1,245 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mapSecrets is a SecretStore backed by a map.
+type mapSecrets struct {
+	values map[string]string
+	failOn string
+}
+
+func newMapSecrets(values map[string]string) *mapSecrets {
+	if values == nil {
+		values = map[string]string{}
+	}
+	return &mapSecrets{values: values}
+}
+
+func (m *mapSecrets) Get(key string) (string, error) {
+	value, ok := m.values[key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func (m *mapSecrets) Set(key, value string) error {
+	if key == m.failOn {
+		return errors.New("secret store unavailable")
+	}
+	m.values[key] = value
+	return nil
+}
+
+func (m *mapSecrets) Delete(key string) error {
+	delete(m.values, key)
+	return nil
+}
+
+const v1Config = `activeprofile: default
+baseurl: https://controller.example
+authtoken: plain-auth-token
+refreshtoken: plain-refresh-token
+splitdns: true
+machine:
+  clientid: machine-1
+  clientsecret: plain-client-secret
+3f2a9c1e-0000-4000-8000-000000000001:
+  clientuuid: 3f2a9c1e-0000-4000-8000-000000000001
+  wgclientinterfaceconfig:
+    clientprivatekey: plain-private-key
+    allowedipaddress: 10.8.0.2/32
+  wgclientpeerconfig:
+    presharedkey: plain-preshared-key
+    vpngatewayport: 51820
+profiles:
+  work:
+    baseurl: https://work.example
+    7b1d0e2f-0000-4000-8000-000000000002:
+      clientuuid: 7b1d0e2f-0000-4000-8000-000000000002
+      wgclientinterfaceconfig:
+        allowedipaddress: 10.9.0.2/32
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readConfigFile(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		t.Fatal(err)
+	}
+	return settings
+}
+
+func section(t *testing.T, settings map[string]interface{}, keys ...string) map[string]interface{} {
+	t.Helper()
+	for _, key := range keys {
+		next, ok := settings[key].(map[string]interface{})
+		if !ok {
+			t.Fatalf("no section %s in %v", strings.Join(keys, "."), settings)
+		}
+		settings = next
+	}
+	return settings
+}
+
+func TestMigrateConfigV1ToV2(t *testing.T) {
+	path := writeConfig(t, v1Config)
+	secrets := newMapSecrets(map[string]string{
+		"profiles.work.authToken": "work-auth-token",
+		"profiles.work.gateway.7b1d0e2f-0000-4000-8000-000000000002.privateKey": "work-private-key",
+	})
+
+	migrated, backupPath, err := migrateConfig(path, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migrated {
+		t.Fatal("version 1 file not migrated")
+	}
+	if backupPath != path+".v1.bak" {
+		t.Errorf("backup path %q", backupPath)
+	}
+
+	settings := readConfigFile(t, path)
+	if settings[lowerSchemaVersion] != CurrentSchemaVersion {
+		t.Errorf("schema version %v", settings[lowerSchemaVersion])
+	}
+	if settings["activeprofile"] != "default" {
+		t.Errorf("global setting moved: %v", settings)
+	}
+	defaultProfile := section(t, settings, ProfilesKey, DefaultProfile)
+	if defaultProfile["baseurl"] != "https://controller.example" || defaultProfile["splitdns"] != true {
+		t.Errorf("default profile settings not moved: %v", defaultProfile)
+	}
+	if section(t, defaultProfile, "machine")["clientid"] != "machine-1" {
+		t.Errorf("machine identity not moved: %v", defaultProfile)
+	}
+	gateway := section(t, defaultProfile, GatewaysKey, "3f2a9c1e-0000-4000-8000-000000000001")
+	if section(t, gateway, "wgclientinterfaceconfig")["allowedipaddress"] != "10.8.0.2/32" {
+		t.Errorf("gateway client not moved: %v", gateway)
+	}
+	section(t, settings, ProfilesKey, "work", GatewaysKey, "7b1d0e2f-0000-4000-8000-000000000002")
+
+	for key, want := range map[string]string{
+		"profiles.default.authToken":                                                  "plain-auth-token",
+		"profiles.default.refreshToken":                                               "plain-refresh-token",
+		"profiles.default.machine.clientSecret":                                       "plain-client-secret",
+		"profiles.default.gateways.3f2a9c1e-0000-4000-8000-000000000001.privateKey":   "plain-private-key",
+		"profiles.default.gateways.3f2a9c1e-0000-4000-8000-000000000001.presharedKey": "plain-preshared-key",
+		"profiles.work.authToken":                                                     "work-auth-token",
+		"profiles.work.gateways.7b1d0e2f-0000-4000-8000-000000000002.privateKey":      "work-private-key",
+	} {
+		if got := secrets.values[key]; got != want {
+			t.Errorf("secret %s = %q, want %q", key, got, want)
+		}
+	}
+	if _, err := secrets.Get("profiles.work.gateway.7b1d0e2f-0000-4000-8000-000000000002.privateKey"); err == nil {
+		t.Error("secret left under its version 1 name")
+	}
+
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		t.Fatalf("backup not kept: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("backup mode %v, want 0600", info.Mode().Perm())
+	}
+	backup := readConfigFile(t, backupPath)
+	if _, exists := backup[lowerSchemaVersion]; exists || backup["baseurl"] != "https://controller.example" {
+		t.Errorf("backup is not the version 1 file: %v", backup)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "plain-") {
+			t.Errorf("%s still holds plaintext credentials:\n%s", entry.Name(), data)
+		}
+	}
+
+	migrated, _, err = migrateConfig(path, secrets)
+	if err != nil || migrated {
+		t.Errorf("second run migrated %v, err %v", migrated, err)
+	}
+}
+
+func TestMigrateConfigFailureKeepsOriginal(t *testing.T) {
+	path := writeConfig(t, v1Config)
+	secrets := newMapSecrets(nil)
+	secrets.failOn = "profiles.default.refreshToken"
+
+	if _, _, err := migrateConfig(path, secrets); err == nil {
+		t.Fatal("migration succeeded although the secret store failed")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != v1Config {
+		t.Errorf("original changed by a failed migration:\n%s", data)
+	}
+	if _, err := os.Stat(path + ".v1.bak"); !os.IsNotExist(err) {
+		t.Error("backup written although the original was not replaced")
+	}
+}
+
+func TestMigrateConfigVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		migrated bool
+		ok       bool
+	}{
+		{name: "empty file", content: "", migrated: true, ok: true},
+		{name: "current version", content: "schemaversion: 2\nprofiles:\n  default:\n    baseurl: https://controller.example\n", ok: true},
+		{name: "newer version", content: "schemaversion: 3\n"},
+		{name: "invalid version", content: "schemaversion: two\n"},
+		{name: "version zero", content: "schemaversion: 0\nbaseurl: x\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfig(t, test.content)
+			migrated, backupPath, err := migrateConfig(path, newMapSecrets(nil))
+			if (err == nil) != test.ok {
+				t.Fatalf("err %v, want ok %v", err, test.ok)
+			}
+			if migrated != test.migrated {
+				t.Errorf("migrated %v, want %v", migrated, test.migrated)
+			}
+			if backupPath != "" {
+				t.Errorf("backup %s written for a file without settings to keep", backupPath)
+			}
+			if !test.ok || !test.migrated {
+				if data, _ := os.ReadFile(path); string(data) != test.content {
+					t.Errorf("file changed:\n%s", data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/logger"
+	"github.com/spf13/viper"
+)
+
+var ErrLockedByPolicy = errors.New("locked by the system policy")
+
+// Login methods an administrator can allow with PolicyLoginMethods.
+const (
+	LoginMethodSSO      = "sso"
+	LoginMethodPassword = "password"
+	LoginMethodDevice   = "device"
+	LoginMethodToken    = "token"
+	LoginMethodMachine  = "machine"
+)
+
+// Policy holds the settings an administrator enforces for every user, from
+// the policy section of the system-wide config file. It is read-only.
+type Policy struct {
+	settings *viper.Viper
+}
+
+func newPolicy() *Policy {
+	return &Policy{settings: viper.New()}
+}
+
+// Locked reports whether key is enforced by the policy.
+func (p *Policy) Locked(key string) bool {
+	if _, ok := LookupSetting(key); !ok {
+		return false
+	}
+	return p.settings.IsSet(key)
+}
+
+// LoginMethodAllowed reports whether users may log in with method. Without
+// PolicyLoginMethods every method is allowed.
+func (p *Policy) LoginMethodAllowed(method string) bool {
+	if !p.settings.IsSet(PolicyLoginMethods) {
+		return true
+	}
+	for _, allowed := range p.settings.GetStringSlice(PolicyLoginMethods) {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteOverridesAllowed reports whether users may change what is routed
+// through the tunnel, which they can unless the policy says otherwise.
+func (p *Policy) RouteOverridesAllowed() bool {
+	if !p.settings.IsSet(PolicyAllowRouteOverrides) {
+		return true
+	}
+	return p.settings.GetBool(PolicyAllowRouteOverrides)
+}
+
+// SystemConfig is the system-wide config file. Its top-level settings are
+// defaults for every profile of every user, its policy section is enforced.
+type SystemConfig struct {
+	Path     string
+	defaults *viper.Viper
+	policy   *Policy
+}
+
+// LoadSystemConfig reads the system-wide config from path. A missing file
+// sets and enforces nothing.
+func LoadSystemConfig(path string) (*SystemConfig, error) {
+	system := &SystemConfig{Path: path, defaults: viper.New(), policy: newPolicy()}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return system, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	system.defaults.SetConfigType(ConfigFileType)
+	if err := system.defaults.ReadConfig(strings.NewReader(string(data))); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if section := system.defaults.Sub(PolicySection); section != nil {
+		system.policy.settings = section
+	}
+	return system, nil
+}
+
+// Policy returns the enforced part of the system config.
+func (c *SystemConfig) Policy() *Policy {
+	return c.policy
+}
+
+// PolicyStore layers the system-wide config and the environment around a
+// Store. Settings resolve, from the highest precedence down, to the policy,
+// a command line flag, the per-user config, a QRYPTIC_* environment variable,
+// the system-wide defaults and the built-in default. Changes to settings
+// enforced by the policy are refused.
+type PolicyStore struct {
+	Store
+	system *SystemConfig
+}
+
+func NewPolicyStore(store Store, system *SystemConfig) *PolicyStore {
+	return &PolicyStore{Store: store, system: system}
+}
+
+func (p *PolicyStore) ForProfile(profile string) Store {
+	return NewPolicyStore(p.Store.ForProfile(profile), p.system)
+}
+
+func (p *PolicyStore) Policy() *Policy {
+	return p.system.policy
+}
+
+func (p *PolicyStore) Setting(key string) (interface{}, Origin) {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return p.Store.Setting(key)
+	}
+	policy := p.system.policy
+	if policy.Locked(setting.Key) {
+		return p.systemValue(setting, policy.settings, OriginPolicy)
+	}
+
+	if !setting.RouteOverride || policy.RouteOverridesAllowed() {
+		if value, origin := p.Store.Setting(setting.Key); origin.Layer == OriginFlag || origin.Layer == OriginUser {
+			return value, origin
+		}
+		if raw, exists := os.LookupEnv(setting.Env); exists && setting.Env != "" {
+			value, err := setting.Parse(raw)
+			if err == nil {
+				return value, Origin{Layer: OriginEnv, Source: setting.Env}
+			}
+			logger.Default().Warn("ignoring invalid environment variable", "name", setting.Env, "error", err)
+		}
+	}
+
+	if p.system.defaults.IsSet(setting.Key) {
+		return p.systemValue(setting, p.system.defaults, OriginSystem)
+	}
+	return setting.Default, Origin{Layer: OriginDefault}
+}
+
+// systemValue reads setting from the system config, falling back to the
+// built-in default when the administrator wrote something invalid.
+func (p *PolicyStore) systemValue(setting Setting, settings *viper.Viper, layer string) (interface{}, Origin) {
+	value, err := setting.Normalize(settings.Get(setting.Key))
+	if err != nil {
+		logger.Default().Warn("ignoring invalid setting", "path", p.system.Path, "error", err)
+		return setting.Default, Origin{Layer: OriginDefault}
+	}
+	return value, Origin{Layer: layer, Source: p.system.Path}
+}
+
+// checkLocked refuses changing key away from the value the policy enforces.
+func (p *PolicyStore) checkLocked(key string, value interface{}) error {
+	if !p.system.policy.Locked(key) {
+		return nil
+	}
+	if enforced, _ := p.Setting(key); fmt.Sprint(enforced) == fmt.Sprint(value) {
+		return nil
+	}
+	return fmt.Errorf("%s is %w", key, ErrLockedByPolicy)
+}
+
+// SetSetting refuses settings enforced by the policy, and route overrides
+// when the policy does not allow them.
+func (p *PolicyStore) SetSetting(key string, value interface{}) error {
+	if err := p.checkWritable(key); err != nil {
+		return err
+	}
+	return p.Store.SetSetting(key, value)
+}
+
+func (p *PolicyStore) UnsetSetting(key string) error {
+	if err := p.checkWritable(key); err != nil {
+		return err
+	}
+	return p.Store.UnsetSetting(key)
+}
+
+func (p *PolicyStore) checkWritable(key string) error {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	if p.system.policy.Locked(setting.Key) {
+		return fmt.Errorf("%s is %w", setting.Key, ErrLockedByPolicy)
+	}
+	if setting.RouteOverride && !p.system.policy.RouteOverridesAllowed() {
+		return fmt.Errorf("%s is a route override, which is %w", setting.Key, ErrLockedByPolicy)
+	}
+	return nil
+}
+
+func (p *PolicyStore) AddProfile(name, baseUrl string) error {
+	if baseUrl != "" {
+		if err := p.checkLocked(BaseUrl, baseUrl); err != nil {
+			return err
+		}
+	}
+	return p.Store.AddProfile(name, baseUrl)
+}
+
+func (p *PolicyStore) GetBaseUrl() (string, bool) {
+	baseUrl := StringSetting(p, BaseUrl)
+	return baseUrl, baseUrl != ""
+}
+
+func (p *PolicyStore) SetBaseUrl(baseUrl string) error {
+	if err := p.checkLocked(BaseUrl, baseUrl); err != nil {
+		return err
+	}
+	if p.system.policy.Locked(BaseUrl) {
+		return nil
+	}
+	return p.Store.SetBaseUrl(baseUrl)
+}
+
+func (p *PolicyStore) GetAuthIssuer() string {
+	return StringSetting(p, AuthIssuer)
+}
+
+func (p *PolicyStore) GetAuthAudience() string {
+	return StringSetting(p, AuthAudience)
+}
+
+func (p *PolicyStore) GetSplitDNSDomains() []string {
+	return StringListSetting(p, SplitDNSDomains)
+}
+
+func (p *PolicyStore) GetSplitDNSListenAddr() string {
+	return StringSetting(p, SplitDNSListenAddr)
+}
+
+func (p *PolicyStore) GetKillSwitch() (bool, bool) {
+	return BoolSetting(p, KillSwitchEnabled), BoolSetting(p, KillSwitchAllowLAN)
+}
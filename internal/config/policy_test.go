@@ -0,0 +1,175 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// layers are the sources a test sets for one setting, empty ones are unset.
+type layers struct {
+	system, env, user, flag, policy string
+	allowRouteOverrides             *bool
+}
+
+func (l layers) store(t *testing.T, key, flagName string) *PolicyStore {
+	t.Helper()
+	setting, _ := LookupSetting(key)
+	if l.env != "" {
+		t.Setenv(setting.Env, l.env)
+	} else {
+		// An empty variable still counts as set
+		unsetenv(t, setting.Env)
+	}
+
+	systemConfig := ""
+	if l.system != "" {
+		systemConfig += fmt.Sprintf("%s: %s\n", key, l.system)
+	}
+	policy := ""
+	if l.policy != "" {
+		policy += fmt.Sprintf("  %s: %s\n", key, l.policy)
+	}
+	if l.allowRouteOverrides != nil {
+		policy += fmt.Sprintf("  %s: %v\n", PolicyAllowRouteOverrides, *l.allowRouteOverrides)
+	}
+	if policy != "" {
+		systemConfig += PolicySection + ":\n" + policy
+	}
+
+	fileStore := newTestFileStore(t)
+	if l.user != "" {
+		must(t, fileStore.SetSetting(key, l.user))
+	}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String(flagName, "", "")
+	must(t, fileStore.BindPFlag(key, flags.Lookup(flagName)))
+	if l.flag != "" {
+		must(t, flags.Set(flagName, l.flag))
+	}
+	return NewPolicyStore(fileStore, writeSystemConfig(t, systemConfig))
+}
+
+func TestPolicyStorePrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers layers
+		value  string
+		origin string
+	}{
+		{name: "built-in default", value: DefaultSplitDNSListenAddr, origin: OriginDefault},
+		{name: "system", layers: layers{system: "10.0.0.1:53"}, value: "10.0.0.1:53", origin: OriginSystem},
+		{name: "env over system", layers: layers{system: "10.0.0.1:53", env: "10.0.0.2:53"}, value: "10.0.0.2:53", origin: OriginEnv},
+		{name: "user over env", layers: layers{system: "10.0.0.1:53", env: "10.0.0.2:53", user: "10.0.0.3:53"}, value: "10.0.0.3:53", origin: OriginUser},
+		{name: "flag over user", layers: layers{env: "10.0.0.2:53", user: "10.0.0.3:53", flag: "10.0.0.4:53"}, value: "10.0.0.4:53", origin: OriginFlag},
+		{name: "policy over flag", layers: layers{system: "10.0.0.1:53", env: "10.0.0.2:53", user: "10.0.0.3:53", flag: "10.0.0.4:53", policy: "10.0.0.5:53"}, value: "10.0.0.5:53", origin: OriginPolicy},
+		{name: "invalid env ignored", layers: layers{system: "10.0.0.1:53", env: "no-port"}, value: "10.0.0.1:53", origin: OriginSystem},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := test.layers.store(t, SplitDNSListenAddr, "dns-listen")
+			value, origin := store.Setting(SplitDNSListenAddr)
+			if value != test.value || origin.Layer != test.origin {
+				t.Errorf("got %v from %s, want %s from %s", value, origin, test.value, test.origin)
+			}
+		})
+	}
+}
+
+func TestPolicyStoreOrigin(t *testing.T) {
+	store := layers{user: "10.0.0.3:53", policy: "10.0.0.5:53"}.store(t, SplitDNSListenAddr, "dns-listen")
+	_, origin := store.Setting(SplitDNSListenAddr)
+	if want := "policy (" + store.system.Path + ")"; origin.String() != want {
+		t.Errorf("origin %q, want %q", origin, want)
+	}
+
+	store = layers{user: "10.0.0.3:53"}.store(t, SplitDNSListenAddr, "dns-listen")
+	if _, origin := store.Setting(SplitDNSListenAddr); origin.Source != store.ConfigFile() {
+		t.Errorf("user origin names %q, want the config file %q", origin.Source, store.ConfigFile())
+	}
+
+	store = layers{env: "10.0.0.2:53"}.store(t, SplitDNSListenAddr, "dns-listen")
+	if _, origin := store.Setting(SplitDNSListenAddr); origin.String() != "env (QRYPTIC_DNS_LISTEN)" {
+		t.Errorf("env origin %q", origin)
+	}
+
+	store = layers{flag: "10.0.0.4:53"}.store(t, SplitDNSListenAddr, "dns-listen")
+	if _, origin := store.Setting(SplitDNSListenAddr); origin.String() != "flag (--dns-listen)" {
+		t.Errorf("flag origin %q", origin)
+	}
+
+	if origin := (Origin{Layer: OriginDefault}); origin.String() != "default" {
+		t.Errorf("default origin %q", origin)
+	}
+}
+
+func TestPolicyStoreLockedKey(t *testing.T) {
+	store := layers{env: "false", user: "false", flag: "false", policy: "true"}.store(t, KillSwitchEnabled, "kill-switch")
+	value, origin := store.Setting(KillSwitchEnabled)
+	if value != true || origin.Layer != OriginPolicy {
+		t.Errorf("got %v from %s, want the enforced true", value, origin)
+	}
+	if enabled, _ := store.GetKillSwitch(); !enabled {
+		t.Error("kill switch not enforced")
+	}
+	if !store.Policy().Locked(KillSwitchEnabled) || store.Policy().Locked(KillSwitchAllowLAN) {
+		t.Error("wrong keys locked")
+	}
+	if err := store.SetSetting(KillSwitchEnabled, false); !errors.Is(err, ErrLockedByPolicy) {
+		t.Errorf("changing a locked key: %v", err)
+	}
+}
+
+func TestPolicyStoreRouteOverrides(t *testing.T) {
+	denied, allowed := false, true
+	tests := []struct {
+		name   string
+		layers layers
+		value  []string
+		origin string
+	}{
+		{
+			name:   "denied, system value applies",
+			layers: layers{system: "[corp.example]", env: "env.example", user: "user.example", flag: "flag.example", allowRouteOverrides: &denied},
+			value:  []string{"corp.example"},
+			origin: OriginSystem,
+		},
+		{
+			name:   "denied without a system value",
+			layers: layers{env: "env.example", user: "user.example", allowRouteOverrides: &denied},
+			value:  []string{},
+			origin: OriginDefault,
+		},
+		{
+			name:   "allowed",
+			layers: layers{system: "[corp.example]", user: "user.example", allowRouteOverrides: &allowed},
+			value:  []string{"user.example"},
+			origin: OriginUser,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := test.layers.store(t, SplitDNSDomains, "split-domains")
+			value, origin := store.Setting(SplitDNSDomains)
+			if fmt.Sprint(value) != fmt.Sprint(test.value) || origin.Layer != test.origin {
+				t.Errorf("got %v from %s, want %v from %s", value, origin, test.value, test.origin)
+			}
+		})
+	}
+
+	// Settings that do not change routing stay with the user
+	store := layers{user: "true", allowRouteOverrides: &denied}.store(t, KillSwitchAllowLAN, "allow-lan")
+	if _, origin := store.Setting(KillSwitchAllowLAN); origin.Layer != OriginUser {
+		t.Errorf("allowLan from %s, want the user config", origin)
+	}
+}
+
+// unsetenv removes key for the rest of the test, t.Setenv restores it after.
+func unsetenv(t *testing.T, key string) {
+	t.Helper()
+	t.Setenv(key, "")
+	must(t, os.Unsetenv(key))
+}
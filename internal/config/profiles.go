@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Profile returns the name of the profile this store is scoped to.
+func (s *FileStore) Profile() string {
+	return s.profile
+}
+
+// ForProfile returns a store sharing the same file, scoped to profile.
+func (s *FileStore) ForProfile(profile string) Store {
+	return s.withProfile(profile)
+}
+
+func (s *FileStore) withProfile(profile string) *FileStore {
+	scoped := *s
+	scoped.profile = profile
+	return &scoped
+}
+
+func (s *FileStore) GetActiveProfile() string {
+	profile := s.v().GetString(ActiveProfile)
+	if profile == "" {
+		return DefaultProfile
+	}
+	return profile
+}
+
+// ListProfiles returns the default profile followed by the added ones.
+func (s *FileStore) ListProfiles() []string {
+	profiles := []string{}
+	for name := range s.v().GetStringMap(ProfilesKey) {
+		if name != DefaultProfile {
+			profiles = append(profiles, name)
+		}
+	}
+	sort.Strings(profiles)
+	return append([]string{DefaultProfile}, profiles...)
+}
+
+func (s *FileStore) ProfileExists(name string) bool {
+	if name == DefaultProfile {
+		return true
+	}
+	_, exists := s.v().GetStringMap(ProfilesKey)[name]
+	return exists
+}
+
+func (s *FileStore) AddProfile(name, baseUrl string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, use lower case letters, digits, - and _", name)
+	}
+	if s.ProfileExists(name) {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	return s.write(map[string]interface{}{s.withProfile(name).key(BaseUrl): baseUrl})
+}
+
+func (s *FileStore) UseProfile(name string) error {
+	if !s.ProfileExists(name) {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	return s.write(map[string]interface{}{ActiveProfile: name})
+}
+
+// RemoveProfile deletes a profile with its credentials. The default profile
+// cannot be removed, only logged out of.
+func (s *FileStore) RemoveProfile(name string) error {
+	if name == DefaultProfile {
+		return fmt.Errorf("the %s profile cannot be removed", DefaultProfile)
+	}
+	if !s.ProfileExists(name) {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	if err := s.ForProfile(name).ClearSecrets(); err != nil {
+		return err
+	}
+	keys := []string{ProfilesKey + "." + name}
+	if s.GetActiveProfile() == name {
+		keys = append(keys, ActiveProfile)
+	}
+	return s.deleteKeys(keys...)
+}
+
+// deleteKeys removes settings from the config file.
+func (s *FileStore) deleteKeys(keys ...string) error {
+	values := map[string]interface{}{}
+	for _, key := range keys {
+		values[key] = nil
+	}
+	return s.write(values)
+}
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/platform"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretStore keeps credentials out of the settings file.
+type SecretStore interface {
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Delete(key string) error
+}
+
+// NewSecretStore returns the store selected by backend. With
+// SecretsBackendAuto the OS keyring is used when it is reachable and the
+// encrypted file in dir otherwise.
+func NewSecretStore(backend, dir string) (SecretStore, error) {
+	switch backend {
+	case SecretsBackendKeyring:
+		if !keyringAvailable() {
+			return nil, errors.New("the OS keyring is not available")
+		}
+		return &keyringSecretStore{service: KeyringService}, nil
+	case SecretsBackendFile:
+		return newFileSecretStore(filepath.Join(dir, SecretsFileName)), nil
+	case SecretsBackendAuto, "":
+		if keyringAvailable() {
+			return &keyringSecretStore{service: KeyringService}, nil
+		}
+		return newFileSecretStore(filepath.Join(dir, SecretsFileName)), nil
+	default:
+		return nil, fmt.Errorf("unknown secrets backend %q", backend)
+	}
+}
+
+func keyringAvailable() bool {
+	// Without a session bus the Secret Service cannot be reached, and asking
+	// for one would try to autolaunch a bus daemon.
+	if runtime.GOOS == "linux" && os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return false
+	}
+	_, err := keyring.Get(KeyringService, "probe")
+	return err == nil || errors.Is(err, keyring.ErrNotFound)
+}
+
+// keyringSecretStore stores secrets in the Secret Service, macOS Keychain or
+// Windows Credential Manager.
+type keyringSecretStore struct {
+	service string
+}
+
+func (k *keyringSecretStore) Get(key string) (string, error) {
+	value, err := keyring.Get(k.service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrSecretNotFound
+	}
+	return value, err
+}
+
+func (k *keyringSecretStore) Set(key, value string) error {
+	return keyring.Set(k.service, key, value)
+}
+
+func (k *keyringSecretStore) Delete(key string) error {
+	err := keyring.Delete(k.service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Where the key of the encrypted secrets file comes from.
+const (
+	secretsKeySourcePassphrase = "passphrase"
+	secretsKeySourceMachine    = "machine"
+)
+
+// secretsFile is the on-disk form of the encrypted secrets. Data is the
+// AES-256-GCM sealed JSON map of all secrets.
+type secretsFile struct {
+	Version   int    `json:"version"`
+	KeySource string `json:"keySource"`
+	Salt      []byte `json:"salt"`
+	Nonce     []byte `json:"nonce"`
+	Data      []byte `json:"data"`
+}
+
+// fileSecretStore encrypts secrets with a key derived by scrypt either from
+// the passphrase in QRYPTIC_SECRETS_PASSPHRASE or from the machine id. The
+// machine-bound key only keeps the file useless when copied to another host,
+// a passphrase also protects it from other users of this one.
+type fileSecretStore struct {
+	path string
+	mu   sync.Mutex
+	// Derived key cache, scrypt is deliberately slow.
+	keySource string
+	salt      []byte
+	key       []byte
+}
+
+func newFileSecretStore(path string) *fileSecretStore {
+	return &fileSecretStore{path: path}
+}
+
+func (f *fileSecretStore) Get(key string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func (f *fileSecretStore) Set(key, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+	secrets[key] = value
+	return f.save(secrets)
+}
+
+func (f *fileSecretStore) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[key]; !ok {
+		return nil
+	}
+	delete(secrets, key)
+	return f.save(secrets)
+}
+
+func (f *fileSecretStore) load() (map[string]string, error) {
+	secrets := map[string]string{}
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Unlike the config file it is not silently fixed, a machine-bound key
+	// only protects the secrets while other users cannot read the file.
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users (mode %#o), restrict it with chmod 600", f.path, info.Mode().Perm())
+	}
+	var file secretsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.path, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("unsupported secrets file version %d", file.Version)
+	}
+	gcm, err := f.cipher(file.KeySource, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Data, []byte(file.KeySource))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s, wrong passphrase or a different machine", f.path)
+	}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.path, err)
+	}
+	return secrets, nil
+}
+
+func (f *fileSecretStore) save(secrets map[string]string) error {
+	keySource := f.keySource
+	salt := f.salt
+	if keySource == "" {
+		keySource = secretsKeySourceMachine
+		if os.Getenv(EnvSecretsPassphrase) != "" {
+			keySource = secretsKeySourcePassphrase
+		}
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+	}
+	gcm, err := f.cipher(keySource, salt)
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(secretsFile{
+		Version:   1,
+		KeySource: keySource,
+		Salt:      salt,
+		Nonce:     nonce,
+		Data:      gcm.Seal(nil, nonce, plaintext, []byte(keySource)),
+	})
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(f.path, data, 0600)
+}
+
+// cipher derives the file key once per process and salt.
+func (f *fileSecretStore) cipher(keySource string, salt []byte) (cipher.AEAD, error) {
+	if f.key == nil || f.keySource != keySource || string(f.salt) != string(salt) {
+		var secret string
+		switch keySource {
+		case secretsKeySourcePassphrase:
+			secret = os.Getenv(EnvSecretsPassphrase)
+			if secret == "" {
+				return nil, fmt.Errorf("%s is protected by a passphrase, set %s", f.path, EnvSecretsPassphrase)
+			}
+		case secretsKeySourceMachine:
+			machineId, err := platform.GetMachineId()
+			if err != nil {
+				return nil, fmt.Errorf("failed to derive the secrets key: %w", err)
+			}
+			secret = "qryptic-secrets:" + machineId
+		default:
+			return nil, fmt.Errorf("unknown secrets key source %q", keySource)
+		}
+		key, err := deriveKey(secret, salt)
+		if err != nil {
+			return nil, err
+		}
+		f.keySource, f.salt, f.key = keySource, salt, key
+	}
+	return newGCM(f.key)
+}
+
+// deriveKey stretches secret into an AES-256 key.
+func deriveKey(secret string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(secret), salt, 1<<15, 8, 1, 32)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EnsurePrivateFile restricts path to its owner and reports whether the
+// permissions had to be tightened.
+func EnsurePrivateFile(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.Mode().Perm()&0077 == 0 {
+		return false, nil
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return false, fmt.Errorf("failed to restrict permissions of %s: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSecretsFile(t *testing.T) string {
+	t.Helper()
+	t.Setenv(EnvSecretsPassphrase, "test passphrase")
+	return filepath.Join(t.TempDir(), SecretsFileName)
+}
+
+func TestFileSecretStoreRoundTrip(t *testing.T) {
+	path := newTestSecretsFile(t)
+	store := newFileSecretStore(path)
+	must(t, store.Set("profiles.default.authToken", "auth-token"))
+	must(t, store.Set("profiles.default.refreshToken", "refresh-token"))
+
+	// A new store has to derive the key from the file again.
+	reopened := newFileSecretStore(path)
+	if value, err := reopened.Get("profiles.default.authToken"); err != nil || value != "auth-token" {
+		t.Errorf("Get = %q, %v", value, err)
+	}
+	must(t, reopened.Delete("profiles.default.authToken"))
+	if _, err := reopened.Get("profiles.default.authToken"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("deleted secret: %v", err)
+	}
+	if value, _ := reopened.Get("profiles.default.refreshToken"); value != "refresh-token" {
+		t.Errorf("other secret %q", value)
+	}
+
+	data, err := os.ReadFile(path)
+	must(t, err)
+	if strings.Contains(string(data), "refresh-token") {
+		t.Error("secrets file holds plain text")
+	}
+	info, err := os.Stat(path)
+	must(t, err)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("secrets file mode %#o", info.Mode().Perm())
+	}
+}
+
+func TestFileSecretStoreWrongPassphrase(t *testing.T) {
+	path := newTestSecretsFile(t)
+	must(t, newFileSecretStore(path).Set("profiles.default.authToken", "auth-token"))
+
+	t.Setenv(EnvSecretsPassphrase, "another passphrase")
+	store := newFileSecretStore(path)
+	if value, err := store.Get("profiles.default.authToken"); err == nil || errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Get with a wrong passphrase = %q, %v", value, err)
+	}
+	// Writing must not replace the secrets it could not read.
+	if err := store.Set("profiles.default.refreshToken", "refresh-token"); err == nil {
+		t.Error("Set with a wrong passphrase succeeded")
+	}
+
+	t.Setenv(EnvSecretsPassphrase, "")
+	if _, err := newFileSecretStore(path).Get("profiles.default.authToken"); err == nil || !strings.Contains(err.Error(), EnvSecretsPassphrase) {
+		t.Errorf("Get without a passphrase: %v", err)
+	}
+}
+
+func TestFileSecretStoreRefusesSharedFile(t *testing.T) {
+	for _, mode := range []os.FileMode{0640, 0604, 0666} {
+		path := newTestSecretsFile(t)
+		must(t, newFileSecretStore(path).Set("profiles.default.authToken", "auth-token"))
+		must(t, os.Chmod(path, mode))
+
+		if value, err := newFileSecretStore(path).Get("profiles.default.authToken"); err == nil {
+			t.Errorf("mode %#o: Get = %q, want a refusal", mode, value)
+		}
+		info, err := os.Stat(path)
+		must(t, err)
+		if info.Mode().Perm() != mode {
+			t.Errorf("mode %#o changed to %#o", mode, info.Mode().Perm())
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// SettingType decides how a setting is parsed and validated.
+type SettingType int
+
+const (
+	SettingString SettingType = iota
+	SettingURL
+	SettingBool
+	SettingHostPort
+	SettingDuration
+	SettingStringList
+	SettingCIDRList
+)
+
+// Setting describes a user preference that can come from the system-wide
+// config, the environment, the per-user config or a command line flag.
+type Setting struct {
+	Key     string
+	Type    SettingType
+	Default interface{}
+	// Env is the environment variable overriding the setting, if any.
+	Env string
+	// RouteOverride settings change what goes through the tunnel, the policy
+	// can restrict them to the system-wide values.
+	RouteOverride bool
+	Description   string
+}
+
+// Settings are the preferences known to the CLI, all of them per profile.
+var Settings = []Setting{
+	{Key: BaseUrl, Type: SettingURL, Default: "", Env: "QRYPTIC_URL", Description: "Url of the qryptic controller"},
+	{Key: AuthIssuer, Type: SettingString, Default: "", Env: "QRYPTIC_AUTH_ISSUER", Description: "Expected issuer of auth tokens, the controller url when empty"},
+	{Key: AuthAudience, Type: SettingString, Default: DefaultAuthAudience, Env: "QRYPTIC_AUTH_AUDIENCE", Description: "Expected audience of auth tokens"},
+	{Key: SplitDNSDomains, Type: SettingStringList, Default: []string{}, Env: "QRYPTIC_SPLIT_DOMAINS", RouteOverride: true, Description: "Only route these domains through the gateway"},
+	{Key: SplitDNSListenAddr, Type: SettingHostPort, Default: DefaultSplitDNSListenAddr, Env: "QRYPTIC_DNS_LISTEN", Description: "Listen address of the split DNS stub"},
+	{Key: KillSwitchEnabled, Type: SettingBool, Default: false, Env: "QRYPTIC_KILL_SWITCH", Description: "Block all traffic outside the tunnel while connected"},
+	{Key: KillSwitchAllowLAN, Type: SettingBool, Default: false, Env: "QRYPTIC_ALLOW_LAN", Description: "Allow local network traffic while the kill switch is active"},
+	{Key: RoutesExclude, Type: SettingCIDRList, Default: []string{}, Env: "QRYPTIC_EXCLUDE_ROUTES", RouteOverride: true, Description: "Networks kept out of the tunnel"},
+	{Key: AuthClockSkew, Type: SettingDuration, Default: JWTClockSkew, Env: "QRYPTIC_AUTH_CLOCK_SKEW", Description: "Clock difference tolerated when checking auth tokens"},
+	{Key: AuthSSOTimeout, Type: SettingDuration, Default: SSOLoginTimeout, Env: "QRYPTIC_SSO_TIMEOUT", Description: "How long to wait for an SSO login to complete"},
+}
+
+// LookupSetting finds a setting by key, ignoring case like viper does.
+func LookupSetting(key string) (Setting, bool) {
+	for _, setting := range Settings {
+		if strings.EqualFold(setting.Key, key) {
+			return setting, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Parse converts the textual form used by flags and environment variables.
+// Lists are comma separated.
+func (s Setting) Parse(raw string) (interface{}, error) {
+	if s.Type == SettingStringList || s.Type == SettingCIDRList {
+		values := []string{}
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		return s.Normalize(values)
+	}
+	return s.Normalize(strings.TrimSpace(raw))
+}
+
+// Normalize converts value to the setting's Go type and validates it.
+func (s Setting) Normalize(value interface{}) (interface{}, error) {
+	switch s.Type {
+	case SettingBool:
+		if raw, ok := value.(string); ok {
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be true or false", s.Key)
+			}
+			return b, nil
+		}
+		b, err := cast.ToBoolE(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", s.Key)
+		}
+		return b, nil
+	case SettingDuration:
+		d, ok := value.(time.Duration)
+		if raw, isString := value.(string); isString {
+			var err error
+			d, err = time.ParseDuration(strings.TrimSpace(raw))
+			ok = err == nil
+		}
+		if !ok || d < 0 {
+			return nil, fmt.Errorf("%s must be a duration like 30s or 5m", s.Key)
+		}
+		return d, nil
+	case SettingStringList, SettingCIDRList:
+		if raw, ok := value.(string); ok {
+			return s.Parse(raw)
+		}
+		values, err := cast.ToStringSliceE(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a list", s.Key)
+		}
+		if s.Type == SettingCIDRList {
+			for _, value := range values {
+				ip, network, err := net.ParseCIDR(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s must be a list of networks like 10.0.0.0/8, %q is not", s.Key, value)
+				}
+				if !ip.Equal(network.IP) {
+					return nil, fmt.Errorf("%s has host bits set in %s, use %s", s.Key, value, network)
+				}
+			}
+		}
+		return values, nil
+	}
+
+	raw, err := cast.ToStringE(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a string", s.Key)
+	}
+	if raw == "" {
+		return raw, nil
+	}
+	switch s.Type {
+	case SettingURL:
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s must be an url like https://qryptic.example.com", s.Key)
+		}
+		return strings.TrimSuffix(raw, "/"), nil
+	case SettingHostPort:
+		if _, _, err := net.SplitHostPort(raw); err != nil {
+			return nil, fmt.Errorf("%s must be a host:port address", s.Key)
+		}
+	}
+	return raw, nil
+}
+
+// Where an effective setting comes from, from the highest precedence down.
+const (
+	OriginPolicy  = "policy"
+	OriginFlag    = "flag"
+	OriginUser    = "user"
+	OriginEnv     = "env"
+	OriginSystem  = "system"
+	OriginDefault = "default"
+)
+
+// Origin explains where the effective value of a setting came from. Source
+// names the file, flag or environment variable.
+type Origin struct {
+	Layer  string
+	Source string
+}
+
+func (o Origin) String() string {
+	if o.Source == "" {
+		return o.Layer
+	}
+	return o.Layer + " (" + o.Source + ")"
+}
+
+// SettingSource is what the typed setting helpers need from a store.
+type SettingSource interface {
+	Setting(key string) (interface{}, Origin)
+}
+
+func StringSetting(source SettingSource, key string) string {
+	value, _ := source.Setting(key)
+	return cast.ToString(value)
+}
+
+func BoolSetting(source SettingSource, key string) bool {
+	value, _ := source.Setting(key)
+	return cast.ToBool(value)
+}
+
+func StringListSetting(source SettingSource, key string) []string {
+	value, _ := source.Setting(key)
+	return cast.ToStringSlice(value)
+}
+
+func DurationSetting(source SettingSource, key string) time.Duration {
+	value, _ := source.Setting(key)
+	return cast.ToDuration(value)
+}
+
+// ValidateConfig checks an edited per-user config file before it replaces
+// current: the schema version must stay, every known setting must be valid
+// and settings enforced by policy must not change.
+func ValidateConfig(current, edited []byte, policy *Policy) error {
+	before, err := readSettings(current)
+	if err != nil {
+		return err
+	}
+	after, err := readSettings(edited)
+	if err != nil {
+		return err
+	}
+	if version := after.GetInt(SchemaVersion); version != CurrentSchemaVersion {
+		return fmt.Errorf("%s must stay %d, it is %d", SchemaVersion, CurrentSchemaVersion, version)
+	}
+	errs := []error{}
+	for name := range after.GetStringMap(ProfilesKey) {
+		if !profileNamePattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid profile name %q, use lower case letters, digits, - and _", name))
+			continue
+		}
+		for _, setting := range Settings {
+			key := ProfilesKey + "." + name + "." + setting.Key
+			if !after.IsSet(key) {
+				continue
+			}
+			if _, err := setting.Normalize(after.Get(key)); err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: %w", name, err))
+			}
+			enforced := policy.Locked(setting.Key) || (setting.RouteOverride && !policy.RouteOverridesAllowed())
+			if enforced && fmt.Sprint(after.Get(key)) != fmt.Sprint(before.Get(key)) {
+				errs = append(errs, fmt.Errorf("profile %s: %s is %w", name, setting.Key, ErrLockedByPolicy))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func readSettings(data []byte) (*viper.Viper, error) {
+	settings := viper.New()
+	settings.SetConfigType(ConfigFileType)
+	if err := settings.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	return settings, nil
+}
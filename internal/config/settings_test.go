@@ -0,0 +1,98 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSettingParse(t *testing.T) {
+	tests := []struct {
+		key     string
+		raw     string
+		want    interface{}
+		wantErr bool
+	}{
+		{key: BaseUrl, raw: "https://qryptic.example.com/", want: "https://qryptic.example.com"},
+		{key: BaseUrl, raw: "", want: ""},
+		{key: BaseUrl, raw: "qryptic.example.com", wantErr: true},
+		{key: BaseUrl, raw: "ftp://qryptic.example.com", wantErr: true},
+		{key: KillSwitchEnabled, raw: "true", want: true},
+		{key: KillSwitchEnabled, raw: " 0 ", want: false},
+		{key: KillSwitchEnabled, raw: "yes", wantErr: true},
+		{key: SplitDNSListenAddr, raw: "127.0.0.1:5353", want: "127.0.0.1:5353"},
+		{key: SplitDNSListenAddr, raw: "127.0.0.1", wantErr: true},
+		{key: SplitDNSDomains, raw: "corp.example, ,internal.example ", want: []string{"corp.example", "internal.example"}},
+		{key: SplitDNSDomains, raw: "", want: []string{}},
+		{key: AuthClockSkew, raw: "30s", want: 30 * time.Second},
+		{key: AuthClockSkew, raw: "0s", want: time.Duration(0)},
+		{key: AuthClockSkew, raw: "-5s", wantErr: true},
+		{key: AuthClockSkew, raw: "30", wantErr: true},
+		{key: AuthSSOTimeout, raw: "five minutes", wantErr: true},
+		{key: RoutesExclude, raw: "10.0.0.0/8, fd00::/8", want: []string{"10.0.0.0/8", "fd00::/8"}},
+		{key: RoutesExclude, raw: "10.1.2.3/8", wantErr: true},
+		{key: RoutesExclude, raw: "10.0.0.0", wantErr: true},
+		{key: RoutesExclude, raw: "10.0.0.0/33", wantErr: true},
+	}
+	for _, tt := range tests {
+		setting, ok := LookupSetting(tt.key)
+		if !ok {
+			t.Fatalf("unknown setting %s", tt.key)
+		}
+		got, err := setting.Parse(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%s, %q) = %v, want an error", tt.key, tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%s, %q): %v", tt.key, tt.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%s, %q) = %#v, want %#v", tt.key, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSettingNormalize(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{key: KillSwitchEnabled, value: true, want: true},
+		{key: KillSwitchEnabled, value: "false", want: false},
+		{key: KillSwitchEnabled, value: []string{"true"}, wantErr: true},
+		{key: AuthClockSkew, value: 2 * time.Minute, want: 2 * time.Minute},
+		{key: AuthClockSkew, value: "1m30s", want: 90 * time.Second},
+		{key: AuthClockSkew, value: -time.Second, wantErr: true},
+		// Bare numbers would silently be nanoseconds
+		{key: AuthClockSkew, value: 30, wantErr: true},
+		{key: SplitDNSDomains, value: []interface{}{"corp.example"}, want: []string{"corp.example"}},
+		{key: SplitDNSDomains, value: "a.example,b.example", want: []string{"a.example", "b.example"}},
+		{key: RoutesExclude, value: []interface{}{"192.168.0.0/16"}, want: []string{"192.168.0.0/16"}},
+		{key: RoutesExclude, value: []string{"192.168.1.1/24"}, wantErr: true},
+		{key: RoutesExclude, value: []string{"fd00::1/64"}, wantErr: true},
+		{key: RoutesExclude, value: []string{"not-a-network"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		setting, _ := LookupSetting(tt.key)
+		got, err := setting.Normalize(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Normalize(%s, %#v) = %v, want an error", tt.key, tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Normalize(%s, %#v): %v", tt.key, tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Normalize(%s, %#v) = %#v, want %#v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
@@ -1,122 +1,520 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/gofrs/flock"
+	"github.com/leetsecure/qryptic-client-cli/internal/logger"
 	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-type Storage struct {
-	vip *viper.Viper
+// FileStore is the Store backed by the per-user YAML config file, with
+// credentials kept in a SecretStore.
+type FileStore struct {
+	vip     *viper.Viper
+	secrets SecretStore
+	profile string
+	state   *fileState
 }
 
-func NewStorage(vipp *viper.Viper) (*Storage, error) {
+// NewFileStore opens the config file scoped to profile, or to the active
+// profile when profile is empty.
+func NewFileStore(vipp *viper.Viper, profile string) (*FileStore, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil, err
 	}
-	viper.AddConfigPath(home)
-	viper.SetConfigType(ConfigFileType)
-	viper.SetConfigName(ConfigFileName)
-	viper.SafeWriteConfig()
-	err = viper.ReadInConfig()
+	vipp.AddConfigPath(home)
+	vipp.SetConfigType(ConfigFileType)
+	vipp.SetConfigName(ConfigFileName)
+	// Create the file private from the start, viper would make it world readable.
+	configPath := filepath.Join(home, ConfigFileName+"."+ConfigFileType)
+	if file, err := os.OpenFile(configPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600); err == nil {
+		file.Close()
+	}
+	err = vipp.ReadInConfig()
 	if err != nil {
 		return nil, err
 	}
-	return &Storage{
-		vip: vipp,
-	}, nil
+	tightened, err := EnsurePrivateFile(vipp.ConfigFileUsed())
+	if err != nil {
+		return nil, err
+	}
+	if tightened {
+		logger.Default().Warn("config file was readable by other users, permissions reset to 0600", "path", vipp.ConfigFileUsed())
+	}
+	secrets, err := NewSecretStore(vipp.GetString(SecretsBackend), home)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		vip:     vipp,
+		secrets: secrets,
+		profile: DefaultProfile,
+		state:   &fileState{flock: flock.New(vipp.ConfigFileUsed() + ".lock"), flags: map[string]*pflag.Flag{}},
+	}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	if profile == "" {
+		profile = s.GetActiveProfile()
+	}
+	profile = strings.ToLower(profile)
+	if !s.ProfileExists(profile) {
+		return nil, fmt.Errorf("profile %q does not exist, add it with qryptic profile add", profile)
+	}
+	s.profile = profile
+	return s, nil
 }
 
-func (s *Storage) GetBaseUrl() (string, bool) {
-	baseUrl := s.vip.GetString(BaseUrl)
-	if baseUrl == "" {
-		return "", false
+// migrate upgrades the config file under the lock, so that two processes
+// started after an upgrade do not both migrate it.
+func (s *FileStore) migrate() error {
+	unlock, err := s.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	migrated, backupPath, err := migrateConfig(s.vip.ConfigFileUsed(), s.secrets)
+	if err != nil {
+		return err
+	}
+	if migrated && backupPath != "" {
+		logger.Default().Info("config file migrated, the previous version is kept without its credentials", "backup", backupPath)
+	}
+	return s.Reload()
+}
+
+// key returns where a per-profile setting lives.
+func (s *FileStore) key(key string) string {
+	return ProfilesKey + "." + s.profile + "." + key
+}
+
+// BindPFlag lets a command line flag override a per-profile setting when it
+// is given. Flags are not written to the file.
+func (s *FileStore) BindPFlag(key string, flag *pflag.Flag) error {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	s.state.flags[setting.Key] = flag
+	return nil
+}
+
+// Setting resolves a setting from a given flag, the file or the built-in
+// default. The system-wide layers are added by PolicyStore.
+func (s *FileStore) Setting(key string) (interface{}, Origin) {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return nil, Origin{}
+	}
+	s.state.mu.Lock()
+	flag := s.state.flags[setting.Key]
+	s.state.mu.Unlock()
+	if flag != nil && flag.Changed {
+		value, err := flagValue(setting, flag)
+		if err == nil {
+			return value, Origin{Layer: OriginFlag, Source: "--" + flag.Name}
+		}
+		logger.Default().Warn("ignoring invalid flag", "flag", flag.Name, "error", err)
+	}
+	if s.v().IsSet(s.key(setting.Key)) {
+		value, err := setting.Normalize(s.vip.Get(s.key(setting.Key)))
+		if err != nil {
+			logger.Default().Warn("ignoring invalid setting", "path", s.vip.ConfigFileUsed(), "error", err)
+		} else if value != "" {
+			// Empty values were written to clear a setting.
+			return value, Origin{Layer: OriginUser, Source: s.vip.ConfigFileUsed()}
+		}
 	}
-	return baseUrl, true
+	return setting.Default, Origin{Layer: OriginDefault}
 }
 
-func (s *Storage) SetBaseUrl(baseUrl string) error {
-	s.vip.Set(BaseUrl, baseUrl)
-	return s.vip.WriteConfig()
+func (s *FileStore) SetSetting(key string, value interface{}) error {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	value, err := setting.Normalize(value)
+	if err != nil {
+		return err
+	}
+	// Keep durations readable in the file.
+	if d, ok := value.(time.Duration); ok {
+		value = d.String()
+	}
+	return s.write(map[string]interface{}{s.key(setting.Key): value})
 }
 
-func (s *Storage) GetAuthToken() (string, bool) {
-	authToken := s.vip.GetString(AuthToken)
-	if authToken == "" {
+func (s *FileStore) UnsetSetting(key string) error {
+	setting, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	return s.deleteKeys(s.key(setting.Key))
+}
+
+func (s *FileStore) ConfigFile() string {
+	return s.vip.ConfigFileUsed()
+}
+
+func flagValue(setting Setting, flag *pflag.Flag) (interface{}, error) {
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		return setting.Normalize(slice.GetSlice())
+	}
+	return setting.Parse(flag.Value.String())
+}
+
+// Policy enforces nothing, see PolicyStore.
+func (s *FileStore) Policy() *Policy {
+	return newPolicy()
+}
+
+// cachedGatewayClient is a gateway client as cached in the config file.
+// Viper lower-cases keys, so the gateway UUID as the controller issued it is
+// kept next to the config.
+type cachedGatewayClient struct {
+	GatewayUuid           string `yaml:"gatewayuuid" mapstructure:"gatewayuuid"`
+	models.WGClientConfig `yaml:",inline" mapstructure:",squash"`
+}
+
+// GetQrypticClientUuids lists the gateways with a cached client config, in
+// the case the controller issued them.
+func (s *FileStore) GetQrypticClientUuids() []string {
+	uuids := []string{}
+	for key, raw := range s.v().GetStringMap(s.key(GatewaysKey)) {
+		uuid := key
+		if gateway, ok := raw.(map[string]interface{}); ok {
+			if original, _ := gateway["gatewayuuid"].(string); strings.EqualFold(original, key) {
+				uuid = original
+			}
+		}
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+func (s *FileStore) getSecret(key string) (string, bool) {
+	value, err := s.secrets.Get(key)
+	if err != nil {
+		if !errors.Is(err, ErrSecretNotFound) {
+			logger.Default().Error("failed to read secret", "key", key, "error", err)
+		}
 		return "", false
 	}
-	return authToken, true
+	return value, value != ""
+}
+
+func (s *FileStore) setSecret(key, value string) error {
+	// The encrypted file store rewrites the whole file, serialise it with
+	// the config writes.
+	unlock, err := s.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if value == "" {
+		return s.secrets.Delete(key)
+	}
+	return s.secrets.Set(key, value)
+}
+
+// gatewayKey and gatewaySecretKey lower-case the UUID, viper does so for
+// the config keys and the secret store has to agree with it.
+func (s *FileStore) gatewayKey(uuid string) string {
+	return s.key(GatewaysKey + "." + strings.ToLower(uuid))
+}
+
+func (s *FileStore) gatewaySecretKey(uuid, name string) string {
+	return s.key(GatewaysKey + "." + strings.ToLower(uuid) + "." + name)
+}
+
+// legacyGatewaySecretKey is where gateway secrets were kept before the UUID
+// was lower-cased.
+func (s *FileStore) legacyGatewaySecretKey(uuid, name string) string {
+	return s.key(GatewaysKey + "." + uuid + "." + name)
+}
+
+func (s *FileStore) getGatewaySecret(uuid, name string) string {
+	if value, ok := s.getSecret(s.gatewaySecretKey(uuid, name)); ok {
+		return value
+	}
+	if uuid == strings.ToLower(uuid) {
+		return ""
+	}
+	value, _ := s.getSecret(s.legacyGatewaySecretKey(uuid, name))
+	return value
+}
+
+// storeGatewaySecrets moves the WireGuard keys of clientConfig into the
+// secret store, leaving them empty in clientConfig.
+func (s *FileStore) storeGatewaySecrets(uuid string, clientConfig *models.WGClientConfig) error {
+	if err := s.setSecret(s.gatewaySecretKey(uuid, "privateKey"), clientConfig.WGClientInterfaceConfig.ClientPrivateKey); err != nil {
+		return err
+	}
+	if err := s.setSecret(s.gatewaySecretKey(uuid, "presharedKey"), clientConfig.WGClientPeerConfig.PresharedKey); err != nil {
+		return err
+	}
+	clientConfig.WGClientInterfaceConfig.ClientPrivateKey = ""
+	clientConfig.WGClientPeerConfig.PresharedKey = ""
+	return nil
+}
+
+// ClearSecrets removes every credential this config put in the secret store.
+func (s *FileStore) ClearSecrets() error {
+	keys := []string{s.key(AuthToken), s.key(RefreshToken), s.key(MachineClientSecret)}
+	for _, uuid := range s.GetQrypticClientUuids() {
+		for _, name := range []string{"privateKey", "presharedKey"} {
+			keys = append(keys, s.gatewaySecretKey(uuid, name))
+			if uuid != strings.ToLower(uuid) {
+				keys = append(keys, s.legacyGatewaySecretKey(uuid, name))
+			}
+		}
+	}
+	for _, key := range keys {
+		if err := s.secrets.Delete(key); err != nil {
+			return fmt.Errorf("failed to delete secret %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) GetBaseUrl() (string, bool) {
+	baseUrl := StringSetting(s, BaseUrl)
+	return baseUrl, baseUrl != ""
+}
+
+func (s *FileStore) SetBaseUrl(baseUrl string) error {
+	return s.write(map[string]interface{}{s.key(BaseUrl): baseUrl})
+}
+
+func (s *FileStore) GetAuthToken() (string, bool) {
+	return s.getSecret(s.key(AuthToken))
+}
+
+func (s *FileStore) SetAuthToken(authToken string) error {
+	return s.setSecret(s.key(AuthToken), authToken)
+}
+
+func (s *FileStore) GetRefreshToken() (string, bool) {
+	return s.getSecret(s.key(RefreshToken))
+}
+
+// SetAuthTokens stores a session token together with the refresh token issued with it.
+func (s *FileStore) SetAuthTokens(authToken, refreshToken string, expiresAt time.Time) error {
+	if err := s.setSecret(s.key(AuthToken), authToken); err != nil {
+		return err
+	}
+	if err := s.setSecret(s.key(RefreshToken), refreshToken); err != nil {
+		return err
+	}
+	return s.write(map[string]interface{}{
+		s.key(AuthTokenType):      AuthTokenTypeSession,
+		s.key(AuthTokenExpiresAt): expiryValue(expiresAt),
+	})
+}
+
+// SetAPIToken stores a pre-issued API or personal access token.
+func (s *FileStore) SetAPIToken(apiToken string) error {
+	if err := s.setSecret(s.key(AuthToken), apiToken); err != nil {
+		return err
+	}
+	if err := s.setSecret(s.key(RefreshToken), ""); err != nil {
+		return err
+	}
+	return s.write(map[string]interface{}{
+		s.key(AuthTokenType):      AuthTokenTypeAPI,
+		s.key(AuthTokenExpiresAt): nil,
+	})
 }
 
-func (s *Storage) SetAuthToken(authToken string) error {
-	s.vip.Set(AuthToken, authToken)
-	return s.vip.WriteConfig()
+// SetMachineToken stores a token issued to the machine identity.
+func (s *FileStore) SetMachineToken(authToken string, expiresAt time.Time) error {
+	if err := s.setSecret(s.key(AuthToken), authToken); err != nil {
+		return err
+	}
+	if err := s.setSecret(s.key(RefreshToken), ""); err != nil {
+		return err
+	}
+	return s.write(map[string]interface{}{
+		s.key(AuthTokenType):      AuthTokenTypeMachine,
+		s.key(AuthTokenExpiresAt): expiryValue(expiresAt),
+	})
 }
 
-func (s *Storage) ClearAuthToken() error {
-	err := s.SetAuthToken("")
+// expiryValue is how a token expiry is written, nil removes it.
+func expiryValue(expiresAt time.Time) interface{} {
+	if expiresAt.IsZero() {
+		return nil
+	}
+	return expiresAt.UTC().Format(time.RFC3339)
+}
+
+func (s *FileStore) GetAuthTokenExpiry() (time.Time, bool) {
+	expiresAt, err := time.Parse(time.RFC3339, s.v().GetString(s.key(AuthTokenExpiresAt)))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// MachineIdentity is what is needed to renew a machine token without a person.
+type MachineIdentity struct {
+	ClientId       string
+	ClientSecret   string
+	PrivateKeyPath string
+}
+
+func (s *FileStore) GetMachineIdentity() (MachineIdentity, string, bool) {
+	identity := MachineIdentity{
+		ClientId:       s.v().GetString(s.key(MachineClientId)),
+		PrivateKeyPath: s.v().GetString(s.key(MachinePrivateKeyPath)),
+	}
+	if identity.ClientId == "" {
+		return identity, "", false
+	}
+	identity.ClientSecret, _ = s.getSecret(s.key(MachineClientSecret))
+	return identity, s.v().GetString(s.key(MachineDeviceId)), true
+}
+
+func (s *FileStore) SetMachineIdentity(identity MachineIdentity, deviceId string) error {
+	if err := s.setSecret(s.key(MachineClientSecret), identity.ClientSecret); err != nil {
+		return err
+	}
+	return s.write(map[string]interface{}{
+		s.key(MachineClientId):       identity.ClientId,
+		s.key(MachinePrivateKeyPath): identity.PrivateKeyPath,
+		s.key(MachineDeviceId):       deviceId,
+	})
+}
+
+func (s *FileStore) GetAuthTokenType() string {
+	authTokenType := s.v().GetString(s.key(AuthTokenType))
+	if authTokenType == "" {
+		return AuthTokenTypeSession
+	}
+	return authTokenType
+}
+
+func (s *FileStore) ClearAuthToken() error {
+	err := s.SetAuthTokens("", "", time.Time{})
 	if err != nil {
 		return err
 	}
 	return s.SetAuthForUrl("")
 }
 
-func (s *Storage) GetAuthForUrl() (string, bool) {
-	authForUrl := s.vip.GetString(AuthForUrl)
+func (s *FileStore) GetAuthForUrl() (string, bool) {
+	authForUrl := s.v().GetString(s.key(AuthForUrl))
 	if authForUrl == "" {
 		return "", false
 	}
 	return authForUrl, true
 }
 
-func (s *Storage) SetAuthForUrl(authForUrl string) error {
-	s.vip.Set(AuthForUrl, authForUrl)
-	return s.vip.WriteConfig()
+func (s *FileStore) SetAuthForUrl(authForUrl string) error {
+	return s.write(map[string]interface{}{s.key(AuthForUrl): authForUrl})
 }
 
-func (s *Storage) GetConnectedToGateway() (string, string, bool) {
-	uuid := s.vip.GetString(ConnectedToGatewayUuid)
-	name := s.vip.GetString(ConnectedToGatewayName)
+func (s *FileStore) GetConnectedToGateway() (string, string, bool) {
+	uuid := s.v().GetString(ConnectedToGatewayUuid)
+	name := s.v().GetString(ConnectedToGatewayName)
 	if uuid == "" || name == "" {
 		return "", "", false
 	}
 	return uuid, name, true
 }
 
-func (s *Storage) SetConnectedToGateway(uuid, name string) error {
-	s.vip.Set(ConnectedToGatewayUuid, uuid)
-	s.vip.Set(ConnectedToGatewayName, name)
-	return s.vip.WriteConfig()
+// SetConnectedToGateway records the tunnel, which is shared by all profiles,
+// together with the profile that brought it up.
+func (s *FileStore) SetConnectedToGateway(uuid, name string) error {
+	return s.write(map[string]interface{}{
+		ConnectedToGatewayUuid:    uuid,
+		ConnectedToGatewayName:    name,
+		ConnectedToGatewayProfile: s.profile,
+	})
 }
 
-func (s *Storage) ClearConnectedToGateway() error {
+// GetConnectedProfile returns the profile the active tunnel belongs to.
+func (s *FileStore) GetConnectedProfile() string {
+	profile := s.v().GetString(ConnectedToGatewayProfile)
+	if profile == "" {
+		return DefaultProfile
+	}
+	return profile
+}
+
+func (s *FileStore) ClearConnectedToGateway() error {
 	return s.SetConnectedToGateway("", "")
 }
 
-func (s *Storage) ClearConfig() error {
-	configFilePath := viper.GetViper().ConfigFileUsed()
-	err := os.Remove(configFilePath)
-	return err
+// ClearProfile logs the profile out: credentials, cached gateway clients and
+// the machine identity are removed, its base URL and defaults are kept.
+func (s *FileStore) ClearProfile() error {
+	if err := s.ClearSecrets(); err != nil {
+		return err
+	}
+	keys := []string{s.key(AuthForUrl), s.key(AuthTokenType), s.key(AuthTokenExpiresAt), s.key(AuthToken), s.key(RefreshToken), s.key(MachineSection)}
+	keys = append(keys, s.key(GatewaysKey))
+	return s.deleteKeys(keys...)
+}
+
+func (s *FileStore) GetQrypticClient(uuid string) (models.WGClientConfig, error) {
+	var cached cachedGatewayClient
+	err := s.v().UnmarshalKey(s.gatewayKey(uuid), &cached)
+	if err != nil {
+		return cached.WGClientConfig, err
+	}
+	qrypticClient := cached.WGClientConfig
+	qrypticClient.WGClientInterfaceConfig.ClientPrivateKey = s.getGatewaySecret(uuid, "privateKey")
+	qrypticClient.WGClientPeerConfig.PresharedKey = s.getGatewaySecret(uuid, "presharedKey")
+	return qrypticClient, nil
+}
+
+// SetQrypticClient caches clientConfig, its WireGuard keys go to the secret store.
+func (s *FileStore) SetQrypticClient(uuid string, clientConfig models.WGClientConfig) error {
+	if err := s.storeGatewaySecrets(uuid, &clientConfig); err != nil {
+		return err
+	}
+	return s.write(map[string]interface{}{s.gatewayKey(uuid): cachedGatewayClient{GatewayUuid: uuid, WGClientConfig: clientConfig}})
+}
+
+func (s *FileStore) GetWireguardSetup() bool {
+	return s.v().GetBool(IsWireguardSetupCompleted)
+}
+
+func (s *FileStore) SetWireguardSetup(isWireguardSetupCompleted bool) error {
+	return s.write(map[string]interface{}{IsWireguardSetupCompleted: isWireguardSetupCompleted})
+}
+
+func (s *FileStore) GetSplitDNSDomains() []string {
+	return StringListSetting(s, SplitDNSDomains)
 }
 
-func (s *Storage) GetQrypticClient(uuid string) (models.WGClientConfig, error) {
-	var qrypticClient models.WGClientConfig
-	err := viper.GetViper().UnmarshalKey(uuid, &qrypticClient)
-	return qrypticClient, err
+func (s *FileStore) GetSplitDNSListenAddr() string {
+	return StringSetting(s, SplitDNSListenAddr)
 }
 
-func (s *Storage) SetQrypticClient(uuid string, clientConfig models.WGClientConfig) error {
-	viper.GetViper().Set(uuid, clientConfig)
-	return viper.GetViper().WriteConfig()
+func (s *FileStore) GetKillSwitch() (bool, bool) {
+	return BoolSetting(s, KillSwitchEnabled), BoolSetting(s, KillSwitchAllowLAN)
 }
 
-func (s *Storage) GetWireguardSetup() bool {
-	return s.vip.GetBool(IsWireguardSetupCompleted)
+// GetAuthIssuer returns the expected token issuer, empty when it is the base URL.
+func (s *FileStore) GetAuthIssuer() string {
+	return StringSetting(s, AuthIssuer)
 }
 
-func (s *Storage) SetWireguardSetup(isWireguardSetupCompleted bool) error {
-	s.vip.Set(IsWireguardSetupCompleted, isWireguardSetupCompleted)
-	return s.vip.WriteConfig()
+func (s *FileStore) GetAuthAudience() string {
+	return StringSetting(s, AuthAudience)
 }
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Store persists the profiles, credentials, gateway client cache and
+// connection state of the CLI. Everything but the profile registry, the
+// connection state and the WireGuard setup flag is scoped to Profile.
+type Store interface {
+	Profile() string
+	// ForProfile returns a store over the same data, scoped to profile.
+	ForProfile(profile string) Store
+	GetActiveProfile() string
+	ListProfiles() []string
+	ProfileExists(name string) bool
+	AddProfile(name, baseUrl string) error
+	UseProfile(name string) error
+	RemoveProfile(name string) error
+
+	GetBaseUrl() (string, bool)
+	SetBaseUrl(baseUrl string) error
+	GetAuthForUrl() (string, bool)
+	SetAuthForUrl(authForUrl string) error
+	// GetAuthIssuer is empty when the issuer is the base URL.
+	GetAuthIssuer() string
+	GetAuthAudience() string
+
+	GetAuthToken() (string, bool)
+	SetAuthToken(authToken string) error
+	GetRefreshToken() (string, bool)
+	// SetAuthTokens and SetMachineToken take the expiry the controller gave
+	// for the token, zero when it gave none.
+	SetAuthTokens(authToken, refreshToken string, expiresAt time.Time) error
+	SetAPIToken(apiToken string) error
+	SetMachineToken(authToken string, expiresAt time.Time) error
+	GetAuthTokenType() string
+	// GetAuthTokenExpiry is the stored expiry of the auth token, for tokens
+	// without an exp claim.
+	GetAuthTokenExpiry() (time.Time, bool)
+	ClearAuthToken() error
+	GetMachineIdentity() (MachineIdentity, string, bool)
+	SetMachineIdentity(identity MachineIdentity, deviceId string) error
+
+	GetQrypticClientUuids() []string
+	GetQrypticClient(uuid string) (models.WGClientConfig, error)
+	SetQrypticClient(uuid string, clientConfig models.WGClientConfig) error
+
+	GetConnectedToGateway() (string, string, bool)
+	SetConnectedToGateway(uuid, name string) error
+	GetConnectedProfile() string
+	ClearConnectedToGateway() error
+	GetWireguardSetup() bool
+	SetWireguardSetup(isWireguardSetupCompleted bool) error
+
+	GetSplitDNSDomains() []string
+	GetSplitDNSListenAddr() string
+	GetKillSwitch() (bool, bool)
+	// Setting returns the effective value of one of Settings and where it
+	// came from.
+	Setting(key string) (interface{}, Origin)
+	// SetSetting validates value and stores it in the user's config,
+	// UnsetSetting removes it so that the lower layers apply again.
+	SetSetting(key string, value interface{}) error
+	UnsetSetting(key string) error
+	Policy() *Policy
+	// ConfigFile is the path of the per-user config, empty when there is none.
+	ConfigFile() string
+
+	// ClearSecrets removes the profile's credentials, ClearProfile also its
+	// cached gateway clients and machine identity.
+	ClearSecrets() error
+	ClearProfile() error
+
+	// Lock serialises read-modify-write cycles with other processes.
+	Lock() (func(), error)
+	Reload() error
+	// BindPFlag lets a command line flag override a per-profile setting.
+	BindPFlag(key string, flag *pflag.Flag) error
+}
+
+// NewStore opens the per-user config file scoped to profile, or to the
+// active profile when profile is empty, layered over the system-wide config.
+func NewStore(vipp *viper.Viper, profile string) (Store, error) {
+	system, err := LoadSystemConfig(SystemConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	fileStore, err := NewFileStore(vipp, profile)
+	if err != nil {
+		return nil, err
+	}
+	return NewPolicyStore(fileStore, system), nil
+}
+
+var _ Store = (*FileStore)(nil)
+var _ Store = (*MemoryStore)(nil)
+var _ Store = (*PolicyStore)(nil)
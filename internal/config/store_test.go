@@ -0,0 +1,317 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/spf13/viper"
+)
+
+// newTestFileStore opens a FileStore in a temporary home directory, with its
+// secrets in the passphrase protected file.
+func newTestFileStore(t *testing.T) *FileStore {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(EnvSecretsPassphrase, "test passphrase")
+	configPath := filepath.Join(home, ConfigFileName+"."+ConfigFileType)
+	if err := os.WriteFile(configPath, []byte("schemaversion: 2\nsecrets:\n  backend: file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(viper.New(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// forEachStore runs test against a MemoryStore and a FileStore.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemoryStore()) })
+	t.Run("file", func(t *testing.T) { test(t, newTestFileStore(t)) })
+}
+
+func testGateway(privateKey string) models.WGClientConfig {
+	return models.WGClientConfig{
+		WGClientInterfaceConfig: models.WGClientInterfaceConfig{ClientPrivateKey: privateKey, AllowedIpAddress: "10.8.0.2/32"},
+		WGClientPeerConfig:      models.WGClientPeerConfig{PresharedKey: "psk-" + privateKey, VpnGatewayPort: 51820},
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreProfileScoping(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		must(t, store.SetBaseUrl("https://home.example"))
+		must(t, store.AddProfile("work", "https://work.example"))
+		if err := store.AddProfile("work", ""); err == nil {
+			t.Error("profile added twice")
+		}
+		work := store.ForProfile("work")
+
+		must(t, store.SetAuthTokens("home-token", "home-refresh", time.Time{}))
+		must(t, work.SetAuthTokens("work-token", "work-refresh", time.Time{}))
+		must(t, store.SetQrypticClient("gw-home", testGateway("home-key")))
+		must(t, work.SetQrypticClient("gw-work", testGateway("work-key")))
+		must(t, work.SetSetting(KillSwitchEnabled, true))
+
+		if token, _ := store.GetAuthToken(); token != "home-token" {
+			t.Errorf("default profile token %q", token)
+		}
+		if token, _ := work.GetAuthToken(); token != "work-token" {
+			t.Errorf("work profile token %q", token)
+		}
+		if baseUrl, _ := work.GetBaseUrl(); baseUrl != "https://work.example" {
+			t.Errorf("work profile base url %q", baseUrl)
+		}
+		if uuids := work.GetQrypticClientUuids(); len(uuids) != 1 || uuids[0] != "gw-work" {
+			t.Errorf("work profile gateways %v", uuids)
+		}
+		if clientConfig, _ := work.GetQrypticClient("gw-work"); clientConfig.WGClientInterfaceConfig.ClientPrivateKey != "work-key" {
+			t.Errorf("work gateway private key %q", clientConfig.WGClientInterfaceConfig.ClientPrivateKey)
+		}
+		if enabled, _ := store.GetKillSwitch(); enabled {
+			t.Error("setting leaked into the default profile")
+		}
+
+		// The tunnel is shared and remembers which profile brought it up
+		must(t, work.SetConnectedToGateway("gw-work", "office"))
+		if uuid, _, connected := store.GetConnectedToGateway(); !connected || uuid != "gw-work" {
+			t.Errorf("connection state not shared: %q %v", uuid, connected)
+		}
+		if profile := store.GetConnectedProfile(); profile != "work" {
+			t.Errorf("connected profile %q", profile)
+		}
+
+		must(t, store.UseProfile("work"))
+		if active := store.GetActiveProfile(); active != "work" {
+			t.Errorf("active profile %q", active)
+		}
+		if err := store.UseProfile("missing"); err == nil {
+			t.Error("switched to a missing profile")
+		}
+		must(t, store.UseProfile(DefaultProfile))
+		must(t, store.RemoveProfile("work"))
+		if store.ProfileExists("work") {
+			t.Error("removed profile still exists")
+		}
+		if err := store.RemoveProfile(DefaultProfile); err == nil {
+			t.Error("default profile removed")
+		}
+	})
+}
+
+func TestStoreClearSecrets(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		must(t, store.SetAuthTokens("token", "refresh", time.Time{}))
+		must(t, store.SetMachineIdentity(MachineIdentity{ClientId: "machine-1", ClientSecret: "machine-secret"}, "device-1"))
+		must(t, store.SetQrypticClient("gw", testGateway("private-key")))
+		must(t, store.AddProfile("other", ""))
+		other := store.ForProfile("other")
+		must(t, other.SetAuthTokens("other-token", "", time.Time{}))
+
+		must(t, store.ClearSecrets())
+		if _, exists := store.GetAuthToken(); exists {
+			t.Error("auth token kept")
+		}
+		if _, exists := store.GetRefreshToken(); exists {
+			t.Error("refresh token kept")
+		}
+		identity, deviceId, exists := store.GetMachineIdentity()
+		if !exists || identity.ClientId != "machine-1" || deviceId != "device-1" {
+			t.Errorf("machine identity removed: %+v %q %v", identity, deviceId, exists)
+		}
+		if identity.ClientSecret != "" {
+			t.Error("machine client secret kept")
+		}
+		clientConfig, err := store.GetQrypticClient("gw")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clientConfig.WGClientInterfaceConfig.AllowedIpAddress != "10.8.0.2/32" {
+			t.Error("gateway client removed")
+		}
+		if clientConfig.WGClientInterfaceConfig.ClientPrivateKey != "" || clientConfig.WGClientPeerConfig.PresharedKey != "" {
+			t.Error("gateway keys kept")
+		}
+		if token, _ := other.GetAuthToken(); token != "other-token" {
+			t.Error("secrets of another profile cleared")
+		}
+	})
+}
+
+func TestStoreClearProfile(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		must(t, store.SetBaseUrl("https://controller.example"))
+		must(t, store.SetAuthForUrl("https://controller.example"))
+		must(t, store.SetAuthTokens("token", "refresh", time.Time{}))
+		must(t, store.SetMachineIdentity(MachineIdentity{ClientId: "machine-1", ClientSecret: "machine-secret"}, "device-1"))
+		must(t, store.SetQrypticClient("gw", testGateway("private-key")))
+		must(t, store.SetSetting(SplitDNSDomains, []string{"corp.example"}))
+
+		must(t, store.ClearProfile())
+		if _, exists := store.GetAuthToken(); exists {
+			t.Error("auth token kept")
+		}
+		if _, exists := store.GetAuthForUrl(); exists {
+			t.Error("auth url kept")
+		}
+		if _, _, exists := store.GetMachineIdentity(); exists {
+			t.Error("machine identity kept")
+		}
+		if uuids := store.GetQrypticClientUuids(); len(uuids) != 0 {
+			t.Errorf("gateway clients kept: %v", uuids)
+		}
+		if baseUrl, _ := store.GetBaseUrl(); baseUrl != "https://controller.example" {
+			t.Errorf("base url %q, want it kept", baseUrl)
+		}
+		if domains := store.GetSplitDNSDomains(); len(domains) != 1 {
+			t.Errorf("settings not kept: %v", domains)
+		}
+	})
+}
+
+func TestStoreAuthTokenExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+		must(t, store.SetAuthTokens("opaque-token", "refresh", expiresAt))
+		if got, exists := store.GetAuthTokenExpiry(); !exists || !got.Equal(expiresAt) {
+			t.Errorf("expiry %v %v, want %v", got, exists, expiresAt)
+		}
+		must(t, store.SetAPIToken("api-token"))
+		if got, exists := store.GetAuthTokenExpiry(); exists {
+			t.Errorf("expiry %v kept for an API token", got)
+		}
+		must(t, store.SetMachineToken("machine-token", expiresAt))
+		must(t, store.ClearProfile())
+		if got, exists := store.GetAuthTokenExpiry(); exists {
+			t.Errorf("expiry %v kept after logout", got)
+		}
+	})
+}
+
+func TestStoreMixedCaseGatewayUuid(t *testing.T) {
+	const uuid = "3F2A9C1E-0000-4000-8000-00000000000A"
+	forEachStore(t, func(t *testing.T, store Store) {
+		must(t, store.SetQrypticClient(uuid, testGateway("private-key")))
+
+		if uuids := store.GetQrypticClientUuids(); len(uuids) != 1 || uuids[0] != uuid {
+			t.Errorf("gateway clients %v, want the UUID as issued", uuids)
+		}
+		for _, lookup := range []string{uuid, strings.ToLower(uuid)} {
+			clientConfig, err := store.GetQrypticClient(lookup)
+			must(t, err)
+			if clientConfig.WGClientInterfaceConfig.ClientPrivateKey != "private-key" || clientConfig.WGClientPeerConfig.PresharedKey != "psk-private-key" {
+				t.Errorf("GetQrypticClient(%s) keys %+v", lookup, clientConfig)
+			}
+		}
+
+		must(t, store.ClearProfile())
+		fileStore, ok := store.(*FileStore)
+		if !ok {
+			return
+		}
+		for _, name := range []string{"privateKey", "presharedKey"} {
+			for _, key := range []string{fileStore.gatewaySecretKey(uuid, name), fileStore.legacyGatewaySecretKey(uuid, name)} {
+				if _, err := fileStore.secrets.Get(key); !errors.Is(err, ErrSecretNotFound) {
+					t.Errorf("secret %s left behind: %v", key, err)
+				}
+			}
+		}
+	})
+}
+
+func TestFileStoreKeepsGatewayUuidCase(t *testing.T) {
+	const uuid = "7B1D0E2F-0000-4000-8000-00000000000B"
+	store := newTestFileStore(t)
+	must(t, store.SetQrypticClient(uuid, testGateway("private-key")))
+
+	reopened, err := NewFileStore(viper.New(), "")
+	must(t, err)
+	if uuids := reopened.GetQrypticClientUuids(); len(uuids) != 1 || uuids[0] != uuid {
+		t.Errorf("gateway clients %v after reload, want %s", uuids, uuid)
+	}
+	clientConfig, err := reopened.GetQrypticClient(uuid)
+	must(t, err)
+	if clientConfig.WGClientInterfaceConfig.AllowedIpAddress != "10.8.0.2/32" || clientConfig.WGClientInterfaceConfig.ClientPrivateKey != "private-key" {
+		t.Errorf("gateway client after reload %+v", clientConfig)
+	}
+}
+
+func TestFileStoreLegacyGatewaySecrets(t *testing.T) {
+	// Secrets written before the UUID was lower-cased are still found and cleared.
+	const uuid = "3F2A9C1E-0000-4000-8000-00000000000C"
+	store := newTestFileStore(t)
+	must(t, store.SetQrypticClient(uuid, testGateway("")))
+	must(t, store.secrets.Set(store.legacyGatewaySecretKey(uuid, "privateKey"), "legacy-key"))
+
+	clientConfig, err := store.GetQrypticClient(uuid)
+	must(t, err)
+	if clientConfig.WGClientInterfaceConfig.ClientPrivateKey != "legacy-key" {
+		t.Errorf("private key %q, want the legacy secret", clientConfig.WGClientInterfaceConfig.ClientPrivateKey)
+	}
+	must(t, store.ClearSecrets())
+	if _, err := store.secrets.Get(store.legacyGatewaySecretKey(uuid, "privateKey")); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("legacy secret left behind: %v", err)
+	}
+}
+
+func writeSystemConfig(t *testing.T, content string) *SystemConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	must(t, os.WriteFile(path, []byte(content), 0644))
+	system, err := LoadSystemConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return system
+}
+
+func TestPolicyStoreRefusals(t *testing.T) {
+	system := writeSystemConfig(t, `policy:
+  baseUrl: https://qryptic.corp.example
+  killSwitch:
+    enabled: true
+  allowRouteOverrides: false
+`)
+	forEachStore(t, func(t *testing.T, inner Store) {
+		store := NewPolicyStore(inner, system)
+
+		for _, err := range []error{
+			store.SetSetting(KillSwitchEnabled, false),
+			store.UnsetSetting(KillSwitchEnabled),
+			store.SetSetting(SplitDNSDomains, []string{"corp.example"}),
+			store.UnsetSetting(SplitDNSDomains),
+			store.SetBaseUrl("https://other.example"),
+			store.AddProfile("other", "https://other.example"),
+		} {
+			if !errors.Is(err, ErrLockedByPolicy) {
+				t.Errorf("got %v, want ErrLockedByPolicy", err)
+			}
+		}
+		if store.ProfileExists("other") {
+			t.Error("profile with a refused base url added")
+		}
+		if err := store.SetSetting("no.such.setting", "x"); err == nil || errors.Is(err, ErrLockedByPolicy) {
+			t.Errorf("unknown setting: %v", err)
+		}
+
+		// The enforced value itself is accepted
+		must(t, store.SetBaseUrl("https://qryptic.corp.example"))
+		must(t, store.AddProfile("work", "https://qryptic.corp.example"))
+		must(t, store.SetSetting(KillSwitchAllowLAN, true))
+		if _, allowLAN := store.GetKillSwitch(); !allowLAN {
+			t.Error("unlocked setting not stored")
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package leaktest
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+// Probe addresses used for default routes, they are never contacted.
+var probeIPv4 = net.ParseIP("1.1.1.1")
+var probeIPv6 = net.ParseIP("2606:4700:4700::1111")
+
+// Result is the outcome of a single leak check.
+type Result struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// Checker verifies that the active tunnel matches the gateway's policy.
+type Checker struct {
+	Interface    string
+	ClientConfig models.WGClientConfig
+	Routes       RouteLookup
+	// Nameservers returns the resolvers the system currently sends queries to.
+	Nameservers func() ([]string, error)
+	DNSTimeout  time.Duration
+	// SplitDNSStub is the listen address of the split DNS stub when the
+	// tunnel runs without routes of its own (Table = off). Only the addresses
+	// resolved for the split domains are routed through it then.
+	SplitDNSStub string
+}
+
+// NewChecker initializes a Checker that inspects the local machine.
+func NewChecker(interfaceName string, clientConfig models.WGClientConfig, nameservers func() ([]string, error)) *Checker {
+	return &Checker{
+		Interface:    interfaceName,
+		ClientConfig: clientConfig,
+		Routes:       NewSystemRoutes(interfaceName),
+		Nameservers:  nameservers,
+		DNSTimeout:   3 * time.Second,
+	}
+}
+
+// Run executes every check and returns the results in a stable order.
+func (c *Checker) Run() []Result {
+	results := c.checkAllowedIPs()
+	results = append(results, c.checkEndpoint())
+	results = append(results, c.checkDNS()...)
+	results = append(results, c.checkIPv6())
+	return results
+}
+
+func (c *Checker) checkAllowedIPs() []Result {
+	if c.SplitDNSStub != "" {
+		return []Result{{Name: "routes", Passed: true, Detail: "split DNS installs routes per resolved address, AllowedIPs are not routed"}}
+	}
+	results := []Result{}
+	for _, allowedIP := range c.ClientConfig.WGClientPeerConfig.AllowedIPs {
+		name := "route " + allowedIP
+		probe, err := probeAddress(allowedIP)
+		if err != nil {
+			results = append(results, Result{Name: name, Passed: false, Detail: err.Error()})
+			continue
+		}
+		results = append(results, c.expectTunnel(name, probe))
+	}
+	return results
+}
+
+func (c *Checker) checkEndpoint() Result {
+	name := "endpoint excluded"
+	endpoint := net.ParseIP(c.ClientConfig.WGClientPeerConfig.VpnGatewayIP)
+	if endpoint == nil {
+		return Result{Name: name, Passed: false, Detail: "gateway endpoint is not an IP address"}
+	}
+	iface, err := c.Routes.EndpointInterfaceFor(endpoint)
+	if err != nil {
+		return Result{Name: name, Passed: false, Detail: err.Error()}
+	}
+	if iface == "" {
+		return Result{Name: name, Passed: false, Detail: fmt.Sprintf("no route to endpoint %s", endpoint)}
+	}
+	if iface == c.Interface {
+		return Result{Name: name, Passed: false, Detail: fmt.Sprintf("endpoint %s is routed into the tunnel itself", endpoint)}
+	}
+	return Result{Name: name, Passed: true, Detail: fmt.Sprintf("endpoint %s leaves via %s", endpoint, iface)}
+}
+
+func (c *Checker) checkDNS() []Result {
+	servers := []string{}
+	for _, server := range strings.Split(c.ClientConfig.WGClientInterfaceConfig.DnsServer, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == 0 {
+		return []Result{{Name: "dns", Passed: true, Detail: "gateway does not provide a DNS server"}}
+	}
+
+	// With split DNS the system asks the stub, which forwards to the gateway
+	expected := servers
+	if c.SplitDNSStub != "" {
+		expected = []string{c.SplitDNSStub}
+	}
+	results := []Result{}
+	configured, err := c.Nameservers()
+	if err != nil {
+		results = append(results, Result{Name: "dns resolver", Passed: false, Detail: err.Error()})
+	} else {
+		missing := []string{}
+		for _, server := range expected {
+			if !containsHost(configured, server) {
+				missing = append(missing, server)
+			}
+		}
+		if len(missing) > 0 {
+			results = append(results, Result{Name: "dns resolver", Passed: false, Detail: fmt.Sprintf("system resolvers %v do not include %v", configured, missing)})
+		} else {
+			results = append(results, Result{Name: "dns resolver", Passed: true, Detail: fmt.Sprintf("system resolvers %v", configured)})
+		}
+	}
+
+	for _, server := range servers {
+		host := hostOf(server)
+		ip := net.ParseIP(host)
+		if ip == nil {
+			results = append(results, Result{Name: "dns " + server, Passed: false, Detail: "not an IP address"})
+			continue
+		}
+		if !ip.IsLoopback() {
+			if result := c.expectTunnel("dns route "+host, ip); !result.Passed {
+				results = append(results, result)
+				continue
+			}
+		}
+		if err := queryServer(server, c.DNSTimeout); err != nil {
+			results = append(results, Result{Name: "dns " + server, Passed: false, Detail: err.Error()})
+			continue
+		}
+		results = append(results, Result{Name: "dns " + server, Passed: true, Detail: "answers through the tunnel"})
+	}
+	return results
+}
+
+func (c *Checker) checkIPv6() Result {
+	name := "ipv6 leak"
+	fullTunnelV4, fullTunnelV6 := false, false
+	for _, allowedIP := range c.ClientConfig.WGClientPeerConfig.AllowedIPs {
+		switch strings.TrimSpace(allowedIP) {
+		case "0.0.0.0/0":
+			fullTunnelV4 = true
+		case "::/0":
+			fullTunnelV6 = true
+		}
+	}
+	if !fullTunnelV4 && !fullTunnelV6 {
+		return Result{Name: name, Passed: true, Detail: "gateway is split-tunnel, IPv6 outside the tunnel is expected"}
+	}
+	if c.SplitDNSStub != "" {
+		return Result{Name: name, Passed: true, Detail: "split DNS only tunnels resolved addresses, IPv6 outside the tunnel is expected"}
+	}
+	iface, err := c.Routes.InterfaceFor(probeIPv6)
+	if err != nil {
+		return Result{Name: name, Passed: false, Detail: err.Error()}
+	}
+	if iface == "" {
+		return Result{Name: name, Passed: true, Detail: "IPv6 has no route"}
+	}
+	if iface != c.Interface {
+		return Result{Name: name, Passed: false, Detail: fmt.Sprintf("IPv6 traffic leaves via %s", iface)}
+	}
+	if !c.ClientConfig.WGClientInterfaceConfig.HasIPv6() {
+		return Result{Name: name, Passed: true, Detail: "IPv6 is blocked by the tunnel"}
+	}
+	return Result{Name: name, Passed: true, Detail: "IPv6 is routed through the tunnel"}
+}
+
+func (c *Checker) expectTunnel(name string, ip net.IP) Result {
+	iface, err := c.Routes.InterfaceFor(ip)
+	if err != nil {
+		return Result{Name: name, Passed: false, Detail: err.Error()}
+	}
+	if iface != c.Interface {
+		if iface == "" {
+			iface = "no route"
+		}
+		return Result{Name: name, Passed: false, Detail: fmt.Sprintf("%s routed via %s, expected %s", ip, iface, c.Interface)}
+	}
+	return Result{Name: name, Passed: true, Detail: fmt.Sprintf("%s routed via %s", ip, iface)}
+}
+
+// probeAddress picks an address inside prefix to ask the routing table about.
+func probeAddress(prefix string) (net.IP, error) {
+	ip, ipNet, err := net.ParseCIDR(strings.TrimSpace(prefix))
+	if err != nil {
+		ip = net.ParseIP(strings.TrimSpace(prefix))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid allowed IP %q", prefix)
+		}
+		return ip, nil
+	}
+	ones, _ := ipNet.Mask.Size()
+	if ones == 0 {
+		if ip.To4() != nil {
+			return probeIPv4, nil
+		}
+		return probeIPv6, nil
+	}
+	// Use the first host address, the network address may have special routes.
+	probe := append(net.IP{}, ipNet.IP...)
+	if len(ipNet.Mask)*8-ones > 1 {
+		probe[len(probe)-1]++
+	}
+	return probe, nil
+}
+
+// containsHost reports whether server is among servers, comparing addresses
+// only since resolv.conf has no ports.
+func containsHost(servers []string, server string) bool {
+	for _, configured := range servers {
+		if configured == server || hostOf(configured) == hostOf(server) {
+			return true
+		}
+	}
+	return false
+}
+
+func hostOf(server string) string {
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		return host
+	}
+	return server
+}
+
+// queryServer sends a root NS query and succeeds on any well-formed reply.
+func queryServer(server string, timeout time.Duration) error {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	idBytes := make([]byte, 2)
+	rand.Read(idBytes)
+	id := binary.BigEndian.Uint16(idBytes)
+	// header: id, RD flag, one question; question: root name, type NS, class IN
+	query := []byte{idBytes[0], idBytes[1], 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 1}
+	if _, err := conn.Write(query); err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("no answer: %w", err)
+	}
+	if n < 12 || binary.BigEndian.Uint16(buf[:2]) != id || buf[2]&0x80 == 0 {
+		return fmt.Errorf("malformed answer")
+	}
+	return nil
+}
+
+// ResolvConfNameservers returns the nameservers listed in /etc/resolv.conf.
+func ResolvConfNameservers() ([]string, error) {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	servers := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers, nil
+}
+
+// ResolvectlNameservers returns the DNS servers systemd-resolved uses for interfaceName.
+func ResolvectlNameservers(interfaceName string) ([]string, error) {
+	output, err := exec.Command("resolvectl", "dns", interfaceName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query systemd-resolved: %w", err)
+	}
+	// Output looks like "Link 5 (wg0): 10.0.0.1 10.0.0.2"
+	_, servers, _ := strings.Cut(string(output), ":")
+	return strings.Fields(servers), nil
+}
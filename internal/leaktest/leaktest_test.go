@@ -0,0 +1,217 @@
+package leaktest
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+// fakeRoutes is a routing table of prefixes to interfaces, the most specific
+// prefix wins. Endpoint lookups use their own table, as with a fwmark.
+type fakeRoutes struct {
+	routes   map[string]string
+	endpoint map[string]string
+}
+
+func (f *fakeRoutes) InterfaceFor(ip net.IP) (string, error) {
+	return longestMatch(f.routes, ip), nil
+}
+
+func (f *fakeRoutes) EndpointInterfaceFor(ip net.IP) (string, error) {
+	if iface := longestMatch(f.endpoint, ip); iface != "" {
+		return iface, nil
+	}
+	return longestMatch(f.routes, ip), nil
+}
+
+func longestMatch(routes map[string]string, ip net.IP) string {
+	best, bestOnes := "", -1
+	for prefix, iface := range routes {
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil || !ipNet.Contains(ip) {
+			continue
+		}
+		if ones, _ := ipNet.Mask.Size(); ones > bestOnes {
+			best, bestOnes = iface, ones
+		}
+	}
+	return best
+}
+
+// startResolver answers every query on a local UDP port, standing in for the
+// gateway DNS server.
+func startResolver(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			response := append([]byte{}, buf[:n]...)
+			response[2] |= 0x80
+			conn.WriteToUDP(response, client)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func fullTunnelConfig(dnsServer string) models.WGClientConfig {
+	return models.WGClientConfig{
+		WGClientInterfaceConfig: models.WGClientInterfaceConfig{
+			AllowedIpAddress: "10.8.0.2/32",
+			DnsServer:        dnsServer,
+		},
+		WGClientPeerConfig: models.WGClientPeerConfig{
+			AllowedIPs:     []string{"0.0.0.0/0", "::/0"},
+			VpnGatewayIP:   "198.51.100.7",
+			VpnGatewayPort: 51820,
+		},
+	}
+}
+
+func newTestChecker(clientConfig models.WGClientConfig, routes *fakeRoutes, nameservers ...string) *Checker {
+	return &Checker{
+		Interface:    "wg0",
+		ClientConfig: clientConfig,
+		Routes:       routes,
+		Nameservers:  func() ([]string, error) { return nameservers, nil },
+		DNSTimeout:   time.Second,
+	}
+}
+
+func resultsByName(results []Result) map[string]Result {
+	byName := map[string]Result{}
+	for _, result := range results {
+		byName[result.Name] = result
+	}
+	return byName
+}
+
+func TestCheckerFullTunnelPasses(t *testing.T) {
+	resolver := startResolver(t)
+	routes := &fakeRoutes{
+		routes:   map[string]string{"0.0.0.0/0": "wg0", "::/0": "wg0"},
+		endpoint: map[string]string{"0.0.0.0/0": "eth0"},
+	}
+	checker := newTestChecker(fullTunnelConfig(resolver), routes, resolver)
+	for _, result := range checker.Run() {
+		if !result.Passed {
+			t.Errorf("%s failed: %s", result.Name, result.Detail)
+		}
+	}
+}
+
+func TestCheckerDetectsLeaks(t *testing.T) {
+	resolver := startResolver(t)
+	routes := &fakeRoutes{
+		// IPv6 escapes, the endpoint is routed into the tunnel
+		routes: map[string]string{"0.0.0.0/0": "wg0", "::/0": "eth0"},
+	}
+	checker := newTestChecker(fullTunnelConfig(resolver), routes, "192.168.1.1")
+	results := resultsByName(checker.Run())
+
+	for _, name := range []string{"route ::/0", "endpoint excluded", "dns resolver", "ipv6 leak"} {
+		if result, ok := results[name]; !ok || result.Passed {
+			t.Errorf("%s: expected a failure, got %+v", name, result)
+		}
+	}
+	if !results["route 0.0.0.0/0"].Passed {
+		t.Errorf("route 0.0.0.0/0 failed: %s", results["route 0.0.0.0/0"].Detail)
+	}
+	if !results["dns "+resolver].Passed {
+		t.Errorf("dns query failed: %s", results["dns "+resolver].Detail)
+	}
+}
+
+func TestCheckerUnreachableResolver(t *testing.T) {
+	// A closed port: nothing answers
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := conn.LocalAddr().String()
+	conn.Close()
+
+	routes := &fakeRoutes{routes: map[string]string{"0.0.0.0/0": "wg0", "::/0": "wg0"}}
+	checker := newTestChecker(fullTunnelConfig(server), routes, server)
+	checker.DNSTimeout = 200 * time.Millisecond
+	if result := resultsByName(checker.Run())["dns "+server]; result.Passed {
+		t.Error("dns check passed without an answer")
+	}
+}
+
+func TestCheckerSplitDNS(t *testing.T) {
+	// Table = off: no default route into the tunnel, only the pinned
+	// gateway DNS server and resolved addresses
+	routes := &fakeRoutes{routes: map[string]string{"0.0.0.0/0": "eth0", "::/0": "eth0", "10.8.0.53/32": "wg0"}}
+	clientConfig := fullTunnelConfig("10.8.0.53")
+	checker := newTestChecker(clientConfig, routes, "127.0.0.1")
+	checker.SplitDNSStub = "127.0.0.1:53"
+	checker.DNSTimeout = 100 * time.Millisecond
+	results := resultsByName(checker.Run())
+
+	for name, result := range results {
+		// The gateway DNS is not reachable from the test
+		if strings.HasPrefix(name, "dns 10.8.0.53") {
+			continue
+		}
+		if !result.Passed {
+			t.Errorf("%s failed in split DNS mode: %s", name, result.Detail)
+		}
+	}
+	if _, ok := results["route 0.0.0.0/0"]; ok {
+		t.Error("AllowedIPs checked in split DNS mode")
+	}
+
+	checker.Nameservers = func() ([]string, error) { return []string{"10.8.0.53"}, nil }
+	if result := resultsByName(checker.Run())["dns resolver"]; result.Passed {
+		t.Error("resolver check passed although the system does not use the stub")
+	}
+}
+
+func TestProbeAddress(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"0.0.0.0/0":     "1.1.1.1",
+		"::/0":          "2606:4700:4700::1111",
+		"10.0.0.0/8":    "10.0.0.1",
+		"10.0.0.5/32":   "10.0.0.5",
+		"fd00::/64":     "fd00::1",
+		" 192.0.2.1 ":   "192.0.2.1",
+		"10.0.0.4/31":   "10.0.0.4",
+		"2001:db8::/48": "2001:db8::1",
+	} {
+		probe, err := probeAddress(prefix)
+		if err != nil {
+			t.Errorf("%q: %v", prefix, err)
+			continue
+		}
+		if !probe.Equal(net.ParseIP(want)) {
+			t.Errorf("%q: probe %v, want %s", prefix, probe, want)
+		}
+	}
+	if _, err := probeAddress("not-a-prefix"); err == nil {
+		t.Error("invalid prefix accepted")
+	}
+}
+
+func TestContainsHost(t *testing.T) {
+	if !containsHost([]string{"127.0.0.1"}, "127.0.0.1:53") {
+		t.Error("resolv.conf address without port did not match")
+	}
+	if !containsHost([]string{"10.0.0.1:53"}, "10.0.0.1") {
+		t.Error("resolvectl address with port did not match")
+	}
+	if containsHost([]string{"10.0.0.2"}, "10.0.0.1") {
+		t.Error("different address matched")
+	}
+}
@@ -0,0 +1,93 @@
+package leaktest
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// RouteLookup resolves which interface traffic to an address leaves through.
+// An empty interface name with a nil error means there is no route at all.
+type RouteLookup interface {
+	InterfaceFor(ip net.IP) (string, error)
+	// EndpointInterfaceFor returns the interface used by the tunnel's own
+	// encrypted packets, which may be routed differently from other traffic.
+	EndpointInterfaceFor(ip net.IP) (string, error)
+}
+
+var linuxRouteDevice = regexp.MustCompile(`\bdev\s+(\S+)`)
+var darwinRouteInterface = regexp.MustCompile(`interface:\s*(\S+)`)
+
+// SystemRoutes queries the kernel routing table of the local machine.
+type SystemRoutes struct {
+	// FirewallMark is the fwmark wg-quick sets on the tunnel's own packets,
+	// needed on Linux to see the route the encrypted packets actually take.
+	FirewallMark string
+}
+
+// NewSystemRoutes initializes SystemRoutes for the given tunnel interface.
+func NewSystemRoutes(interfaceName string) *SystemRoutes {
+	routes := &SystemRoutes{}
+	if runtime.GOOS == "linux" {
+		output, err := exec.Command("wg", "show", interfaceName, "fwmark").Output()
+		if err == nil {
+			mark := strings.TrimSpace(string(output))
+			if mark != "" && mark != "off" {
+				routes.FirewallMark = mark
+			}
+		}
+	}
+	return routes
+}
+
+// InterfaceFor implements RouteLookup.
+func (r *SystemRoutes) InterfaceFor(ip net.IP) (string, error) {
+	return r.lookup(ip, "")
+}
+
+// EndpointInterfaceFor implements RouteLookup using the tunnel's firewall mark.
+func (r *SystemRoutes) EndpointInterfaceFor(ip net.IP) (string, error) {
+	return r.lookup(ip, r.FirewallMark)
+}
+
+func (r *SystemRoutes) lookup(ip net.IP, mark string) (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		args := []string{"route", "get", ip.String()}
+		if mark != "" {
+			args = append(args, "mark", mark)
+		}
+		output, err := exec.Command("ip", args...).CombinedOutput()
+		if err != nil {
+			if strings.Contains(string(output), "unreachable") {
+				return "", nil
+			}
+			return "", fmt.Errorf("failed to look up route for %s: %w: %s", ip, err, strings.TrimSpace(string(output)))
+		}
+		if match := linuxRouteDevice.FindStringSubmatch(string(output)); match != nil {
+			return match[1], nil
+		}
+		return "", nil
+	case "darwin":
+		family := "-inet"
+		if ip.To4() == nil {
+			family = "-inet6"
+		}
+		output, err := exec.Command("route", "-n", "get", family, ip.String()).CombinedOutput()
+		if err != nil {
+			if strings.Contains(string(output), "not in table") {
+				return "", nil
+			}
+			return "", fmt.Errorf("failed to look up route for %s: %w: %s", ip, err, strings.TrimSpace(string(output)))
+		}
+		if match := darwinRouteInterface.FindStringSubmatch(string(output)); match != nil {
+			return match[1], nil
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("route lookup is not supported on %s", runtime.GOOS)
+	}
+}
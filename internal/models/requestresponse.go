@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // ErrorResponse represents an error response from the API.
 // type ErrorResponse struct {
@@ -13,10 +18,118 @@ type EmailPasswordLoginRequest struct {
 	Password string `json:"password"`
 }
 
+// AuthResponse carries the tokens of a login or refresh. ExpiresAt or
+// ExpiresIn, in seconds, give the lifetime of the auth token for tokens that
+// carry no exp claim.
 type AuthResponse struct {
-	AuthToken string `json:"authToken"`
-	Error     string `json:"error"`
-	Message   string `json:"message"`
+	AuthToken        string    `json:"authToken"`
+	RefreshToken     string    `json:"refreshToken"`
+	ExpiresIn        int       `json:"expiresIn"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshExpiresIn int       `json:"refreshExpiresIn"`
+	Error            string    `json:"error"`
+	Message          string    `json:"message"`
+	// Set instead of a token when the account requires a second factor.
+	MfaRequired bool     `json:"mfaRequired"`
+	ChallengeId string   `json:"challengeId"`
+	MfaMethods  []string `json:"mfaMethods"`
+}
+
+// AuthTokenExpiry is when the auth token expires according to the response,
+// zero when the controller did not say. issuedAt is when the response was
+// received.
+func (r *AuthResponse) AuthTokenExpiry(issuedAt time.Time) time.Time {
+	if !r.ExpiresAt.IsZero() {
+		return r.ExpiresAt
+	}
+	if r.ExpiresIn > 0 {
+		return issuedAt.Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return time.Time{}
+}
+
+// MachineTokenRequest authenticates a machine identity with the
+// client_credentials grant, using either a secret or a signed assertion.
+type MachineTokenRequest struct {
+	GrantType           string `json:"grant_type"`
+	ClientId            string `json:"client_id"`
+	ClientSecret        string `json:"client_secret,omitempty"`
+	ClientAssertionType string `json:"client_assertion_type,omitempty"`
+	ClientAssertion     string `json:"client_assertion,omitempty"`
+	DeviceId            string `json:"device_id"`
+	Hostname            string `json:"hostname"`
+}
+
+type MfaVerifyRequest struct {
+	ChallengeId string `json:"challengeId"`
+	Method      string `json:"method"`
+	Code        string `json:"code"`
+}
+
+type MfaEmailCodeRequest struct {
+	ChallengeId string `json:"challengeId"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// RevokeTokenRequest follows RFC 7009, TokenTypeHint is "access_token" or "refresh_token".
+type RevokeTokenRequest struct {
+	Token         string `json:"token"`
+	TokenTypeHint string `json:"tokenTypeHint,omitempty"`
+}
+
+// DeviceAuthorizationResponse is the controller's answer to an RFC 8628
+// device authorization request.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type DeviceTokenRequest struct {
+	GrantType  string `json:"grant_type"`
+	DeviceCode string `json:"device_code"`
+}
+
+// LoginProvider is a login method enabled on the controller. SSO providers
+// carry the controller paths used to start the flow and fetch the token.
+type LoginProvider struct {
+	Id           string `json:"id"`
+	Type         string `json:"type"`
+	DisplayName  string `json:"displayName"`
+	InitiatePath string `json:"initiatePath"`
+	TokenPath    string `json:"tokenPath"`
+	ExchangePath string `json:"exchangePath"`
+}
+
+// SSOTokenRequest polls for the tokens of an SSO login started with
+// CodeChallenge. The verifier is only ever sent in the request body.
+type SSOTokenRequest struct {
+	CodeVerifier  string `json:"codeVerifier"`
+	CodeChallenge string `json:"codeChallenge"`
+}
+
+type SSOCodeExchangeRequest struct {
+	Code         string `json:"code"`
+	CodeVerifier string `json:"codeVerifier"`
+	RedirectUri  string `json:"redirectUri"`
+	State        string `json:"state"`
+}
+
+type LoginProvidersResponse struct {
+	Providers []LoginProvider `json:"providers"`
+}
+
+type CurrentUserResponse struct {
+	Email        string   `json:"email"`
+	Name         string   `json:"name"`
+	Organisation string   `json:"organisation"`
+	Roles        []string `json:"roles"`
 }
 
 type GatewayResponse struct {
@@ -28,6 +141,23 @@ type GatewayResponse struct {
 	Uuid            string `json:"uuid"`
 }
 
+// JSONWebKey is a single public key published by the controller.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type JWKSResponse struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
 type HealthCheckResponse struct {
 	Success bool `json:"success"`
 }
@@ -38,9 +168,11 @@ type PromptContent struct {
 }
 
 type WGClientInterfaceConfig struct {
-	ClientPrivateKey string `json:"privateKey"`
-	AllowedIpAddress string `json:"ipAddress"`
-	DnsServer        string `json:"dnsServer"`
+	ClientPrivateKey string   `json:"privateKey"`
+	AllowedIpAddress string   `json:"ipAddress"`
+	IpAddresses      []string `json:"ipAddresses"`
+	DnsServer        string   `json:"dnsServer"`
+	DnsDomains       []string `json:"dnsDomains"`
 }
 
 type WGClientPeerConfig struct {
@@ -53,6 +185,51 @@ type WGClientPeerConfig struct {
 	VpnGatewayPort   int      `json:"vpnGatewayPort"`
 }
 
+// Addresses returns every IPv4 and IPv6 address of the interface. Older
+// controllers only send a single, possibly comma separated, ipAddress.
+func (c WGClientInterfaceConfig) Addresses() []string {
+	addresses := []string{}
+	for _, address := range append(strings.Split(c.AllowedIpAddress, ","), c.IpAddresses...) {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		duplicate := false
+		for _, existing := range addresses {
+			if existing == address {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// HasIPv6 reports whether the interface has at least one IPv6 address.
+func (c WGClientInterfaceConfig) HasIPv6() bool {
+	for _, address := range c.Addresses() {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			ip = net.ParseIP(address)
+		}
+		if ip != nil && ip.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Endpoint returns the gateway endpoint as host:port, with IPv6 addresses in brackets.
+func (c WGClientPeerConfig) Endpoint() string {
+	host := c.VpnGatewayIP
+	if host == "" {
+		host = c.VpnGatewayDomain
+	}
+	return net.JoinHostPort(host, strconv.Itoa(c.VpnGatewayPort))
+}
+
 type WGClientConfig struct {
 	ClientUuid              string                  `json:"clientUuid"`
 	WGClientInterfaceConfig WGClientInterfaceConfig `json:"clientInterfaceConfig"`
@@ -0,0 +1,85 @@
+package pkce
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// The example of RFC 7636 appendix B: 32 random octets, the verifier they
+// encode to and its S256 challenge.
+var rfcOctets = []byte{116, 24, 223, 180, 151, 153, 224, 37, 79, 250, 96, 125, 216, 173, 187, 186,
+	22, 212, 37, 77, 105, 214, 191, 240, 91, 88, 5, 88, 83, 132, 141, 121}
+
+const (
+	rfcVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfcChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestChallengeRFC7636(t *testing.T) {
+	// GenerateVerifier encodes its random octets the same way
+	if got := base64.RawURLEncoding.EncodeToString(rfcOctets); got != rfcVerifier {
+		t.Errorf("octets encode to %q, want %q", got, rfcVerifier)
+	}
+	if got := Challenge(rfcVerifier); got != rfcChallenge {
+		t.Errorf("Challenge(%q) = %q, want %q", rfcVerifier, got, rfcChallenge)
+	}
+	if err := ValidateVerifier(rfcVerifier); err != nil {
+		t.Errorf("RFC verifier rejected: %v", err)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		verifier, challenge, err := Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateVerifier(verifier); err != nil {
+			t.Fatalf("generated verifier %q: %v", verifier, err)
+		}
+		if len(verifier) != MinVerifierLength {
+			t.Errorf("verifier length %d, want %d", len(verifier), MinVerifierLength)
+		}
+		if challenge != Challenge(verifier) {
+			t.Errorf("challenge %q does not belong to verifier %q", challenge, verifier)
+		}
+		if strings.ContainsAny(challenge, "+/=") {
+			t.Errorf("challenge %q is not base64url without padding", challenge)
+		}
+		if seen[verifier] {
+			t.Fatalf("verifier %q generated twice", verifier)
+		}
+		seen[verifier] = true
+	}
+}
+
+func TestValidateVerifier(t *testing.T) {
+	tests := map[string]struct {
+		verifier string
+		ok       bool
+	}{
+		"minimum length":      {strings.Repeat("a", MinVerifierLength), true},
+		"maximum length":      {strings.Repeat("Z", MaxVerifierLength), true},
+		"unreserved symbols":  {strings.Repeat("-._~", 11), true},
+		"too short":           {strings.Repeat("a", MinVerifierLength-1), false},
+		"too long":            {strings.Repeat("a", MaxVerifierLength+1), false},
+		"empty":               {"", false},
+		"plus sign":           {strings.Repeat("a", 42) + "+", false},
+		"slash":               {strings.Repeat("a", 42) + "/", false},
+		"padding":             {strings.Repeat("a", 42) + "=", false},
+		"space":               {strings.Repeat("a", 42) + " ", false},
+		"non-ASCII letter":    {strings.Repeat("a", 42) + "é", false},
+		"old 40 char default": {strings.Repeat("a", 40), false},
+	}
+	for name, test := range tests {
+		err := ValidateVerifier(test.verifier)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
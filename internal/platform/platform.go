@@ -2,6 +2,7 @@ package platform
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -82,6 +83,54 @@ func OpenURL(url string) error {
 	return exec.Command(cmd, args...).Start()
 }
 
+// GetMachineId returns a stable identifier of this host, as assigned by the OS.
+func GetMachineId() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		output, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to read platform UUID: %w", err)
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			if strings.Contains(line, "IOPlatformUUID") {
+				parts := strings.Split(line, "\"")
+				if len(parts) >= 4 {
+					return parts[3], nil
+				}
+			}
+		}
+		return "", fmt.Errorf("platform UUID not found")
+	default:
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			data, err := os.ReadFile(path)
+			if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+				return strings.TrimSpace(string(data)), nil
+			}
+		}
+		return "", fmt.Errorf("machine id not found")
+	}
+}
+
+// HasLocalBrowser reports whether a browser can be opened on this machine,
+// i.e. the CLI is not running in a plain SSH session or on a headless host.
+func HasLocalBrowser() bool {
+	switch runtime.GOOS {
+	case "windows":
+		return true
+	case "darwin":
+		return os.Getenv("SSH_CONNECTION") == "" && os.Getenv("SSH_TTY") == ""
+	default:
+		if isWSL() {
+			return true
+		}
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return false
+		}
+		_, err := exec.LookPath("xdg-open")
+		return err == nil
+	}
+}
+
 // isWSL checks if the Go program is running inside Windows Subsystem for Linux
 func isWSL() bool {
 	releaseData, err := exec.Command("uname", "-r").Output()
@@ -103,3 +152,74 @@ func GetConfigDirectory() string {
 		return "/etc/wireguard"
 	}
 }
+
+// AddHostRoute routes traffic for a single address through the given interface.
+func AddHostRoute(ip net.IP, interfaceName string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		family := "-inet"
+		if ip.To4() == nil {
+			family = "-inet6"
+		}
+		cmd = exec.Command("route", "-n", "add", family, "-host", ip.String(), "-interface", interfaceName)
+	case "linux":
+		cmd = exec.Command("ip", ipFamilyFlag(ip), "route", "replace", hostPrefix(ip), "dev", interfaceName)
+	default:
+		return fmt.Errorf("host routes are not supported on %s", runtime.GOOS)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add route for %s: %w: %s", ip, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// DeleteHostRoute removes a route previously added by AddHostRoute.
+func DeleteHostRoute(ip net.IP, interfaceName string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		family := "-inet"
+		if ip.To4() == nil {
+			family = "-inet6"
+		}
+		cmd = exec.Command("route", "-n", "delete", family, "-host", ip.String(), "-interface", interfaceName)
+	case "linux":
+		cmd = exec.Command("ip", ipFamilyFlag(ip), "route", "del", hostPrefix(ip), "dev", interfaceName)
+	default:
+		return fmt.Errorf("host routes are not supported on %s", runtime.GOOS)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete route for %s: %w: %s", ip, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func ipFamilyFlag(ip net.IP) string {
+	if ip.To4() == nil {
+		return "-6"
+	}
+	return "-4"
+}
+
+func hostPrefix(ip net.IP) string {
+	if ip.To4() == nil {
+		return ip.String() + "/128"
+	}
+	return ip.String() + "/32"
+}
+
+// GetSystemNameserver returns the first nameserver configured in /etc/resolv.conf.
+func GetSystemNameserver() (string, error) {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver found in /etc/resolv.conf")
+}
@@ -0,0 +1,132 @@
+package splitdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+const (
+	dnsHeaderLen = 12
+	typeA        = 1
+	typeAAAA     = 28
+	classIN      = 1
+)
+
+var errMalformedMessage = errors.New("malformed DNS message")
+
+// AnswerRecord is an address record taken from the answer section of a DNS response.
+type AnswerRecord struct {
+	IP  net.IP
+	TTL uint32
+}
+
+// QuestionName returns the name of the first question in a DNS message.
+func QuestionName(msg []byte) (string, error) {
+	if len(msg) < dnsHeaderLen {
+		return "", errMalformedMessage
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", errMalformedMessage
+	}
+	name, _, err := readName(msg, dnsHeaderLen)
+	return name, err
+}
+
+// Truncated reports whether the TC flag of a DNS message is set, meaning the
+// answer did not fit and has to be asked for again over TCP.
+func Truncated(msg []byte) bool {
+	return len(msg) >= dnsHeaderLen && msg[2]&0x02 != 0
+}
+
+// AddressAnswers returns all A and AAAA records in the answer section of a DNS response.
+func AddressAnswers(msg []byte) ([]AnswerRecord, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, errMalformedMessage
+	}
+	qdCount := int(binary.BigEndian.Uint16(msg[4:6]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:8]))
+
+	offset := dnsHeaderLen
+	for i := 0; i < qdCount; i++ {
+		_, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		// qtype + qclass
+		offset = next + 4
+		if offset > len(msg) {
+			return nil, errMalformedMessage
+		}
+	}
+
+	records := []AnswerRecord{}
+	for i := 0; i < anCount; i++ {
+		_, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		// type(2) + class(2) + ttl(4) + rdlength(2)
+		if next+10 > len(msg) {
+			return nil, errMalformedMessage
+		}
+		rrType := binary.BigEndian.Uint16(msg[next : next+2])
+		rrClass := binary.BigEndian.Uint16(msg[next+2 : next+4])
+		ttl := binary.BigEndian.Uint32(msg[next+4 : next+8])
+		rdLength := int(binary.BigEndian.Uint16(msg[next+8 : next+10]))
+		rdStart := next + 10
+		if rdStart+rdLength > len(msg) {
+			return nil, errMalformedMessage
+		}
+		rdata := msg[rdStart : rdStart+rdLength]
+		if rrClass == classIN {
+			if rrType == typeA && rdLength == net.IPv4len {
+				records = append(records, AnswerRecord{IP: net.IP(append([]byte{}, rdata...)), TTL: ttl})
+			} else if rrType == typeAAAA && rdLength == net.IPv6len {
+				records = append(records, AnswerRecord{IP: net.IP(append([]byte{}, rdata...)), TTL: ttl})
+			}
+		}
+		offset = rdStart + rdLength
+	}
+	return records, nil
+}
+
+// readName decodes a possibly compressed domain name starting at offset and
+// returns the name together with the offset right after it in the message.
+func readName(msg []byte, offset int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	// Bound the number of compression pointers followed to avoid loops.
+	for jumps := 0; jumps < 64; {
+		if offset >= len(msg) {
+			return "", 0, errMalformedMessage
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(msg) {
+				return "", 0, errMalformedMessage
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:offset+2]) & 0x3FFF)
+			jumps++
+		case length&0xC0 != 0:
+			return "", 0, errMalformedMessage
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errMalformedMessage
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+	return "", 0, errMalformedMessage
+}
@@ -0,0 +1,132 @@
+package splitdns
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// encodeName writes name as uncompressed labels.
+func encodeName(name string) []byte {
+	out := []byte{}
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0)
+}
+
+func buildQuery(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, dnsHeaderLen)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	msg[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	msg = append(msg, encodeName(name)...)
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(msg, qtype), classIN)
+}
+
+type testRecord struct {
+	rrType uint16
+	ttl    uint32
+	rdata  []byte
+}
+
+// buildResponse answers query with records whose owner name is a compression
+// pointer to the question.
+func buildResponse(query []byte, records ...testRecord) []byte {
+	msg := append([]byte{}, query...)
+	msg[2] |= 0x80 // QR
+	binary.BigEndian.PutUint16(msg[6:8], uint16(len(records)))
+	for _, record := range records {
+		msg = append(msg, 0xC0, dnsHeaderLen)
+		msg = binary.BigEndian.AppendUint16(msg, record.rrType)
+		msg = binary.BigEndian.AppendUint16(msg, classIN)
+		msg = binary.BigEndian.AppendUint32(msg, record.ttl)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(record.rdata)))
+		msg = append(msg, record.rdata...)
+	}
+	return msg
+}
+
+func TestQuestionName(t *testing.T) {
+	name, err := QuestionName(buildQuery(1, "git.corp.example", typeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "git.corp.example" {
+		t.Errorf("got %q, want git.corp.example", name)
+	}
+}
+
+func TestQuestionNameMalformed(t *testing.T) {
+	query := buildQuery(1, "git.corp.example", typeA)
+	noQuestion := append([]byte{}, query...)
+	binary.BigEndian.PutUint16(noQuestion[4:6], 0)
+	loop := append(append([]byte{}, query[:dnsHeaderLen]...), 0xC0, dnsHeaderLen)
+
+	tests := map[string][]byte{
+		"short header":       query[:5],
+		"no question":        noQuestion,
+		"truncated label":    query[:dnsHeaderLen+3],
+		"pointer loop":       loop,
+		"reserved label bit": append(append([]byte{}, query[:dnsHeaderLen]...), 0x80, 0),
+	}
+	for name, msg := range tests {
+		if _, err := QuestionName(msg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAddressAnswers(t *testing.T) {
+	query := buildQuery(7, "git.corp.example", typeA)
+	response := buildResponse(query,
+		testRecord{rrType: typeA, ttl: 300, rdata: []byte{10, 1, 2, 3}},
+		testRecord{rrType: typeAAAA, ttl: 60, rdata: net.ParseIP("fd00::1")},
+		// CNAME, not an address
+		testRecord{rrType: 5, ttl: 60, rdata: encodeName("other.corp.example")},
+		// An A record with a wrong length is skipped
+		testRecord{rrType: typeA, ttl: 60, rdata: []byte{1, 2, 3}},
+	)
+	answers, err := AddressAnswers(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("got %d answers, want 2: %v", len(answers), answers)
+	}
+	if !answers[0].IP.Equal(net.IPv4(10, 1, 2, 3)) || answers[0].TTL != 300 {
+		t.Errorf("first answer %v ttl %d", answers[0].IP, answers[0].TTL)
+	}
+	if !answers[1].IP.Equal(net.ParseIP("fd00::1")) || answers[1].TTL != 60 {
+		t.Errorf("second answer %v ttl %d", answers[1].IP, answers[1].TTL)
+	}
+}
+
+func TestAddressAnswersMalformed(t *testing.T) {
+	response := buildResponse(buildQuery(7, "git.corp.example", typeA),
+		testRecord{rrType: typeA, ttl: 300, rdata: []byte{10, 1, 2, 3}})
+	for cut := dnsHeaderLen; cut < len(response); cut++ {
+		if _, err := AddressAnswers(response[:cut]); err == nil {
+			t.Errorf("response cut at %d of %d bytes parsed without error", cut, len(response))
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	response := buildResponse(buildQuery(7, "git.corp.example", typeA))
+	if Truncated(response) {
+		t.Error("response without TC reported as truncated")
+	}
+	response[2] |= 0x02
+	if !Truncated(response) {
+		t.Error("response with TC not reported as truncated")
+	}
+	if Truncated(response[:2]) {
+		t.Error("short message reported as truncated")
+	}
+}
@@ -0,0 +1,109 @@
+package splitdns
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/platform"
+)
+
+// RouteManager installs host routes through the tunnel interface and removes
+// them again once the DNS record they were learned from has expired.
+type RouteManager struct {
+	Interface string
+	MinTTL    time.Duration
+
+	mu     sync.Mutex
+	routes map[string]*hostRoute
+	// addRoute and deleteRoute change the routing table, replaced in tests.
+	addRoute, deleteRoute func(ip net.IP, interfaceName string) error
+}
+
+type hostRoute struct {
+	timer   *time.Timer
+	expires time.Time
+}
+
+// NewRouteManager initializes a new RouteManager for the given interface.
+func NewRouteManager(interfaceName string, minTTL time.Duration) *RouteManager {
+	return &RouteManager{
+		Interface:   interfaceName,
+		MinTTL:      minTTL,
+		routes:      map[string]*hostRoute{},
+		addRoute:    platform.AddHostRoute,
+		deleteRoute: platform.DeleteHostRoute,
+	}
+}
+
+// Add routes ip through the tunnel until ttl has passed. Adding an address
+// that is already routed only extends its lifetime.
+func (r *RouteManager) Add(ip net.IP, ttl time.Duration) error {
+	if ttl < r.MinTTL {
+		ttl = r.MinTTL
+	}
+	key := ip.String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if route, ok := r.routes[key]; ok {
+		if route.timer != nil && time.Now().Add(ttl).After(route.expires) {
+			route.expires = time.Now().Add(ttl)
+			route.timer.Reset(ttl)
+		}
+		return nil
+	}
+	if err := r.addRoute(ip, r.Interface); err != nil {
+		return err
+	}
+	r.routes[key] = &hostRoute{
+		timer:   time.AfterFunc(ttl, func() { r.expire(key, ip) }),
+		expires: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+// Pin routes ip through the tunnel until Flush is called.
+func (r *RouteManager) Pin(ip net.IP) error {
+	key := ip.String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if route, ok := r.routes[key]; ok {
+		if route.timer != nil {
+			route.timer.Stop()
+			route.timer = nil
+		}
+		return nil
+	}
+	if err := r.addRoute(ip, r.Interface); err != nil {
+		return err
+	}
+	r.routes[key] = &hostRoute{}
+	return nil
+}
+
+// Flush removes every route installed by the manager.
+func (r *RouteManager) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, route := range r.routes {
+		if route.timer != nil {
+			route.timer.Stop()
+		}
+		r.deleteRoute(net.ParseIP(key), r.Interface)
+		delete(r.routes, key)
+	}
+}
+
+func (r *RouteManager) expire(key string, ip net.IP) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	route, ok := r.routes[key]
+	// The route was pinned, flushed or extended while the timer fired.
+	if !ok || route.timer == nil || time.Now().Before(route.expires) {
+		return
+	}
+	r.deleteRoute(ip, r.Interface)
+	delete(r.routes, key)
+}
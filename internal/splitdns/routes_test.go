@@ -0,0 +1,127 @@
+package splitdns
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRoutes records the routes a RouteManager installs instead of changing
+// the routing table.
+type fakeRoutes struct {
+	mu      sync.Mutex
+	routes  map[string]string
+	adds    int
+	deletes int
+}
+
+func newTestRouteManager(minTTL time.Duration) (*RouteManager, *fakeRoutes) {
+	fake := &fakeRoutes{routes: map[string]string{}}
+	r := NewRouteManager("wg0", minTTL)
+	r.addRoute = func(ip net.IP, interfaceName string) error {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.routes[ip.String()] = interfaceName
+		fake.adds++
+		return nil
+	}
+	r.deleteRoute = func(ip net.IP, interfaceName string) error {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		delete(fake.routes, ip.String())
+		fake.deletes++
+		return nil
+	}
+	return r, fake
+}
+
+func (f *fakeRoutes) has(ip string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.routes[ip]
+	return ok
+}
+
+func (f *fakeRoutes) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.adds, f.deletes
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRouteManagerAddExpires(t *testing.T) {
+	r, fake := newTestRouteManager(0)
+	if err := r.Add(net.ParseIP("10.1.2.3"), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if fake.routes["10.1.2.3"] != "wg0" {
+		t.Fatalf("route not installed on wg0: %v", fake.routes)
+	}
+	waitFor(t, "route to expire", func() bool { return !fake.has("10.1.2.3") })
+}
+
+func TestRouteManagerAddExtends(t *testing.T) {
+	r, fake := newTestRouteManager(0)
+	ip := net.ParseIP("10.1.2.3")
+	if err := r.Add(ip, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(ip, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !fake.has("10.1.2.3") {
+		t.Error("route expired although a later answer extended it")
+	}
+	if adds, _ := fake.counts(); adds != 1 {
+		t.Errorf("route installed %d times, want once", adds)
+	}
+	r.Flush()
+}
+
+func TestRouteManagerMinTTL(t *testing.T) {
+	r, fake := newTestRouteManager(time.Hour)
+	if err := r.Add(net.ParseIP("fd00::1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !fake.has("fd00::1") {
+		t.Error("route with a zero TTL expired before the minimum TTL")
+	}
+	r.Flush()
+}
+
+func TestRouteManagerPinAndFlush(t *testing.T) {
+	r, fake := newTestRouteManager(0)
+	if err := r.Add(net.ParseIP("10.0.0.53"), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Pin(net.ParseIP("10.0.0.53")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(net.ParseIP("10.1.2.3"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !fake.has("10.0.0.53") {
+		t.Error("pinned route expired")
+	}
+	r.Flush()
+	if fake.has("10.0.0.53") || fake.has("10.1.2.3") {
+		t.Errorf("routes left after Flush: %v", fake.routes)
+	}
+	if _, deletes := fake.counts(); deletes != 2 {
+		t.Errorf("%d routes deleted, want 2", deletes)
+	}
+}
@@ -0,0 +1,261 @@
+package splitdns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/logger"
+)
+
+// maxDNSMessageSize is the largest message that fits a UDP datagram or a TCP
+// length prefix.
+const maxDNSMessageSize = 65535
+
+// Stub is a local DNS forwarder. Queries for the configured domains are sent
+// to the gateway resolver and the addresses in the answers are routed through
+// the tunnel; every other query goes to the system resolver untouched. It
+// serves UDP and TCP, so that clients can retry truncated answers over TCP.
+type Stub struct {
+	ListenAddr string
+	TunnelDNS  string
+	SystemDNS  string
+	Domains    []string
+	Routes     *RouteManager
+	Timeout    time.Duration
+
+	udp *net.UDPConn
+	tcp *net.TCPListener
+}
+
+// NewStub initializes a new Stub.
+func NewStub(listenAddr, tunnelDNS, systemDNS string, domains []string, routes *RouteManager) *Stub {
+	normalized := []string{}
+	for _, domain := range domains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		domain = strings.TrimPrefix(domain, "*.")
+		if domain != "" {
+			normalized = append(normalized, domain)
+		}
+	}
+	return &Stub{
+		ListenAddr: listenAddr,
+		TunnelDNS:  withDefaultPort(tunnelDNS),
+		SystemDNS:  withDefaultPort(systemDNS),
+		Domains:    normalized,
+		Routes:     routes,
+		Timeout:    5 * time.Second,
+	}
+}
+
+// Listen opens the UDP and TCP sockets of the stub, so that the resolver can
+// be pointed at it before Run serves queries.
+func (s *Stub) Listen() error {
+	if s.udp != nil {
+		return nil
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", s.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address: %w", err)
+	}
+	udp, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start DNS stub: %w", err)
+	}
+	// A port of 0 picks one, TCP has to use the same
+	tcpAddr := &net.TCPAddr{IP: udpAddr.IP, Port: udp.LocalAddr().(*net.UDPAddr).Port, Zone: udpAddr.Zone}
+	tcp, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("failed to start DNS stub: %w", err)
+	}
+	s.udp, s.tcp = udp, tcp
+	return nil
+}
+
+// Addr returns the address the stub listens on, once Listen has been called.
+func (s *Stub) Addr() string {
+	if s.udp == nil {
+		return s.ListenAddr
+	}
+	return s.udp.LocalAddr().String()
+}
+
+// Run serves DNS queries until ctx is cancelled.
+func (s *Stub) Run(ctx context.Context) error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		s.udp.Close()
+		s.tcp.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.serveTCP(ctx)
+	}()
+	defer wg.Wait()
+	defer s.tcp.Close()
+
+	buf := make([]byte, maxDNSMessageSize)
+	for {
+		n, clientAddr, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read DNS query: %w", err)
+		}
+		query := append([]byte{}, buf[:n]...)
+		go func() {
+			if response := s.resolve(query, "udp"); response != nil {
+				s.udp.WriteToUDP(response, clientAddr)
+			}
+		}()
+	}
+}
+
+func (s *Stub) serveTCP(ctx context.Context) {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Default().Error("DNS stub stopped accepting TCP connections", "error", err.Error())
+			}
+			return
+		}
+		go s.handleTCP(conn)
+	}
+}
+
+// handleTCP answers the queries of one TCP connection until the client
+// closes it or stays idle for longer than the timeout.
+func (s *Stub) handleTCP(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+		response := s.resolve(query, "tcp")
+		if response == nil {
+			return
+		}
+		if err := writeTCPMessage(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// Matches reports whether name belongs to one of the split domains.
+func (s *Stub) Matches(name string) bool {
+	name = strings.Trim(strings.ToLower(name), ".")
+	for _, domain := range s.Domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve forwards query over network and routes the addresses answered for
+// split domains. It returns nil when no answer could be obtained.
+func (s *Stub) resolve(query []byte, network string) []byte {
+	log := logger.Default()
+	name, err := QuestionName(query)
+	if err != nil {
+		return nil
+	}
+
+	viaTunnel := s.Matches(name)
+	upstream := s.SystemDNS
+	if viaTunnel {
+		upstream = s.TunnelDNS
+	}
+	response, err := s.forward(network, upstream, query)
+	if err != nil {
+		log.Error("DNS forwarding failed", "name", name, "upstream", upstream, "error", err.Error())
+		return nil
+	}
+
+	// A truncated answer is incomplete, the client retries it over TCP and
+	// the routes are installed from the full answer then.
+	if viaTunnel && !Truncated(response) {
+		answers, err := AddressAnswers(response)
+		if err != nil {
+			log.Error("Could not parse DNS response", "name", name, "error", err.Error())
+		}
+		for _, answer := range answers {
+			if err := s.Routes.Add(answer.IP, time.Duration(answer.TTL)*time.Second); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+	return response
+}
+
+func (s *Stub) forward(network, upstream string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, upstream, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxDNSMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// readTCPMessage reads a message with the two byte length prefix of DNS over TCP.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > maxDNSMessageSize {
+		return errors.New("DNS message too large")
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func withDefaultPort(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, "53")
+}
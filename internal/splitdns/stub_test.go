@@ -0,0 +1,213 @@
+package splitdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers every query with one A record, over UDP and TCP. With
+// truncateUDP it answers UDP queries with TC set and no records.
+type fakeResolver struct {
+	addr        string
+	answer      net.IP
+	truncateUDP bool
+}
+
+func startFakeResolver(t *testing.T, answer net.IP, truncateUDP bool) *fakeResolver {
+	t.Helper()
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	tcp, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		udp.Close()
+		t.Skipf("cannot listen on TCP port %d: %v", port, err)
+	}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+	r := &fakeResolver{addr: udp.LocalAddr().String(), answer: answer, truncateUDP: truncateUDP}
+
+	go func() {
+		buf := make([]byte, maxDNSMessageSize)
+		for {
+			n, client, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteToUDP(r.respond(buf[:n], true), client)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				writeTCPMessage(conn, r.respond(query, false))
+			}()
+		}
+	}()
+	return r
+}
+
+func (r *fakeResolver) respond(query []byte, udp bool) []byte {
+	if udp && r.truncateUDP {
+		response := buildResponse(query)
+		response[2] |= 0x02
+		return response
+	}
+	return buildResponse(query, testRecord{rrType: typeA, ttl: 300, rdata: r.answer.To4()})
+}
+
+func startStub(t *testing.T, tunnelDNS, systemDNS string) (*Stub, *fakeRoutes) {
+	t.Helper()
+	routes, fake := newTestRouteManager(0)
+	stub := NewStub("127.0.0.1:0", tunnelDNS, systemDNS, []string{"*.corp.example."}, routes)
+	stub.Timeout = time.Second
+	if err := stub.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- stub.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+		routes.Flush()
+	})
+	return stub, fake
+}
+
+func queryUDP(t *testing.T, server, name string) []byte {
+	t.Helper()
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(buildQuery(1, name, typeA)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxDNSMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func queryTCP(t *testing.T, server, name string) []byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := writeTCPMessage(conn, buildQuery(1, name, typeA)); err != nil {
+		t.Fatal(err)
+	}
+	response, err := readTCPMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func firstAnswer(t *testing.T, response []byte) net.IP {
+	t.Helper()
+	answers, err := AddressAnswers(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) == 0 {
+		t.Fatal("no answers")
+	}
+	return answers[0].IP
+}
+
+func TestStubMatches(t *testing.T) {
+	stub := NewStub("127.0.0.1:53", "10.0.0.53", "192.168.1.1", []string{"*.corp.example.", " Lab.Example "}, nil)
+	for name, want := range map[string]bool{
+		"corp.example":        true,
+		"git.corp.example.":   true,
+		"GIT.CORP.EXAMPLE":    true,
+		"x.lab.example":       true,
+		"notcorp.example":     false,
+		"corp.example.com":    false,
+		"www.public.example":  false,
+		"lab.example.invalid": false,
+	} {
+		if got := stub.Matches(name); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestStubRoutesSplitDomains(t *testing.T) {
+	tunnel := startFakeResolver(t, net.IPv4(10, 1, 2, 3), false)
+	system := startFakeResolver(t, net.IPv4(93, 184, 216, 34), false)
+	stub, fake := startStub(t, tunnel.addr, system.addr)
+
+	if ip := firstAnswer(t, queryUDP(t, stub.Addr(), "git.corp.example")); !ip.Equal(net.IPv4(10, 1, 2, 3)) {
+		t.Errorf("split domain answered with %v, want the tunnel resolver's answer", ip)
+	}
+	if !fake.has("10.1.2.3") {
+		t.Error("no route installed for the split domain answer")
+	}
+
+	if ip := firstAnswer(t, queryUDP(t, stub.Addr(), "www.public.example")); !ip.Equal(net.IPv4(93, 184, 216, 34)) {
+		t.Errorf("other domain answered with %v, want the system resolver's answer", ip)
+	}
+	if fake.has("93.184.216.34") {
+		t.Error("route installed for a domain outside the split domains")
+	}
+}
+
+func TestStubTCP(t *testing.T) {
+	tunnel := startFakeResolver(t, net.IPv4(10, 1, 2, 4), false)
+	system := startFakeResolver(t, net.IPv4(93, 184, 216, 34), false)
+	stub, fake := startStub(t, tunnel.addr, system.addr)
+
+	if ip := firstAnswer(t, queryTCP(t, stub.Addr(), "db.corp.example")); !ip.Equal(net.IPv4(10, 1, 2, 4)) {
+		t.Errorf("answered with %v over TCP", ip)
+	}
+	if !fake.has("10.1.2.4") {
+		t.Error("no route installed for an answer received over TCP")
+	}
+}
+
+func TestStubTruncatedAnswer(t *testing.T) {
+	tunnel := startFakeResolver(t, net.IPv4(10, 1, 2, 5), true)
+	system := startFakeResolver(t, net.IPv4(93, 184, 216, 34), false)
+	stub, fake := startStub(t, tunnel.addr, system.addr)
+
+	if response := queryUDP(t, stub.Addr(), "big.corp.example"); !Truncated(response) {
+		t.Fatal("truncated answer was not passed on to the client")
+	}
+	if adds, _ := fake.counts(); adds != 0 {
+		t.Errorf("%d routes installed from a truncated answer", adds)
+	}
+	// The client retries over TCP, which gets the full answer
+	if ip := firstAnswer(t, queryTCP(t, stub.Addr(), "big.corp.example")); !ip.Equal(net.IPv4(10, 1, 2, 5)) {
+		t.Errorf("answered with %v over TCP", ip)
+	}
+	if !fake.has("10.1.2.5") {
+		t.Error("no route installed after the TCP retry")
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// RenderQRCode draws text as a QR code for the terminal, using half-block
+// characters so that two module rows fit in one line of text.
+func RenderQRCode(text string) (string, error) {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return "", err
+	}
+	// The spec asks for a quiet zone of four modules around the symbol.
+	const quietZone = 4
+	size := code.Size + 2*quietZone
+	black := func(x, y int) bool {
+		return code.Black(x-quietZone, y-quietZone)
+	}
+
+	var b strings.Builder
+	for y := 0; y < size; y += 2 {
+		for x := 0; x < size; x++ {
+			top, bottom := black(x, y), y+1 < size && black(x, y+1)
+			// Light modules are drawn, so the code stays readable on dark terminals.
+			switch {
+			case !top && !bottom:
+				b.WriteString("█")
+			case !top:
+				b.WriteString("▀")
+			case !bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,344 @@
+// Package conf reads and writes WireGuard configs in the INI dialect of
+// wg-quick. A parsed Config keeps every line, including comments, blank lines
+// and keys it does not know, so that writing it back reproduces the input
+// byte for byte except for what was changed.
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	SectionInterface = "Interface"
+	SectionPeer      = "Peer"
+)
+
+// Line is a key = value pair, a comment or a blank line. Comment holds a
+// trailing comment including its '#', for a comment line it is the whole
+// line.
+type Line struct {
+	Key     string
+	Value   string
+	Comment string
+	// raw is the line as parsed, written back while the line is unchanged.
+	raw                                   string
+	parsed                                bool
+	parsedKey, parsedValue, parsedComment string
+}
+
+// IsKey reports whether the line holds a key rather than only a comment.
+func (l *Line) IsKey() bool {
+	return l.Key != ""
+}
+
+func (l *Line) String() string {
+	return strings.TrimSuffix(l.text(false), "\r")
+}
+
+// text is the line as written, a parsed line keeps its own line ending.
+func (l *Line) text(crlf bool) string {
+	if l.parsed && l.Key == l.parsedKey && l.Value == l.parsedValue && l.Comment == l.parsedComment {
+		return l.raw
+	}
+	line := l.Comment
+	if l.Key != "" {
+		line = l.Key + " = " + l.Value
+		if l.Comment != "" {
+			line += " " + l.Comment
+		}
+	}
+	if crlf {
+		line += "\r"
+	}
+	return line
+}
+
+// Section is an [Interface], [Peer] or unknown section with its lines.
+type Section struct {
+	Name  string
+	Lines []*Line
+	// header is the section line as parsed, it may carry a comment.
+	header     string
+	parsedName string
+}
+
+func (s *Section) headerText(crlf bool) string {
+	if s.parsedName != "" && s.Name == s.parsedName {
+		return s.header
+	}
+	if crlf {
+		return "[" + s.Name + "]\r"
+	}
+	return "[" + s.Name + "]"
+}
+
+// Get returns the value of the first occurrence of key.
+func (s *Section) Get(key string) (string, bool) {
+	for _, line := range s.Lines {
+		if strings.EqualFold(line.Key, key) {
+			return line.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns the value of every occurrence of key, in order.
+func (s *Section) GetAll(key string) []string {
+	values := []string{}
+	for _, line := range s.Lines {
+		if strings.EqualFold(line.Key, key) {
+			values = append(values, line.Value)
+		}
+	}
+	return values
+}
+
+// List returns the comma separated values of every occurrence of key, as
+// wg-quick reads Address, DNS and AllowedIPs.
+func (s *Section) List(key string) []string {
+	values := []string{}
+	for _, value := range s.GetAll(key) {
+		values = append(values, splitList(value)...)
+	}
+	return values
+}
+
+// Set replaces the first occurrence of key, keeping its position and
+// comment, and removes any other. Missing keys are added after the last key
+// of the section.
+func (s *Section) Set(key, value string) {
+	found := false
+	lines := s.Lines[:0]
+	for _, line := range s.Lines {
+		if strings.EqualFold(line.Key, key) {
+			if found {
+				continue
+			}
+			line.Value = value
+			found = true
+		}
+		lines = append(lines, line)
+	}
+	s.Lines = lines
+	if !found {
+		s.Add(key, value)
+	}
+}
+
+// Add appends an occurrence of key after the last key of the section, so
+// that comments and blank lines ending the section stay in front of the next.
+func (s *Section) Add(key, value string) {
+	at := 0
+	for i, line := range s.Lines {
+		if line.IsKey() {
+			at = i + 1
+		}
+	}
+	s.Lines = append(s.Lines, nil)
+	copy(s.Lines[at+1:], s.Lines[at:])
+	s.Lines[at] = &Line{Key: key, Value: value}
+}
+
+// Delete removes every occurrence of key.
+func (s *Section) Delete(key string) {
+	lines := s.Lines[:0]
+	for _, line := range s.Lines {
+		if !strings.EqualFold(line.Key, key) {
+			lines = append(lines, line)
+		}
+	}
+	s.Lines = lines
+}
+
+// Config is a parsed wg-quick config.
+type Config struct {
+	// Preamble holds the comments and blank lines before the first section.
+	Preamble []*Line
+	Sections []*Section
+	crlf     bool
+	// noFinalNewline is set when the parsed input did not end with one.
+	noFinalNewline bool
+}
+
+// New returns an empty config.
+func New() *Config {
+	return &Config{}
+}
+
+// ParseError is returned for lines wg-quick would not accept.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Parse reads a wg-quick config. As in wg-quick everything after a '#' is a
+// comment, keys are matched without regard to case and a value runs from the
+// first '=' to the end of the line.
+func Parse(data []byte) (*Config, error) {
+	c := New()
+	text := string(data)
+	if text == "" {
+		return c, nil
+	}
+	if !strings.HasSuffix(text, "\n") {
+		c.noFinalNewline = true
+	} else {
+		text = text[:len(text)-1]
+	}
+	rawLines := strings.Split(text, "\n")
+	// Lines added later follow the line ending of the first one
+	c.crlf = strings.HasSuffix(rawLines[0], "\r")
+
+	var section *Section
+	for i, raw := range rawLines {
+		content, comment := strings.TrimSuffix(raw, "\r"), ""
+		if idx := strings.IndexByte(content, '#'); idx >= 0 {
+			content, comment = content[:idx], content[idx:]
+		}
+		stripped := strings.TrimSpace(content)
+
+		switch {
+		case stripped == "":
+			line := &Line{Comment: strings.TrimSpace(comment), raw: raw, parsed: true}
+			line.parsedComment = line.Comment
+			if section == nil {
+				c.Preamble = append(c.Preamble, line)
+			} else {
+				section.Lines = append(section.Lines, line)
+			}
+		case strings.HasPrefix(stripped, "["):
+			if !strings.HasSuffix(stripped, "]") {
+				return nil, &ParseError{Line: i + 1, Msg: fmt.Sprintf("unterminated section header %q", stripped)}
+			}
+			name := strings.TrimSpace(stripped[1 : len(stripped)-1])
+			if name == "" {
+				return nil, &ParseError{Line: i + 1, Msg: "empty section name"}
+			}
+			section = &Section{Name: name, header: raw, parsedName: name}
+			c.Sections = append(c.Sections, section)
+		default:
+			eq := strings.IndexByte(stripped, '=')
+			if eq < 0 {
+				return nil, &ParseError{Line: i + 1, Msg: fmt.Sprintf("expected key = value, got %q", stripped)}
+			}
+			key := strings.TrimSpace(stripped[:eq])
+			if key == "" {
+				return nil, &ParseError{Line: i + 1, Msg: "missing key before '='"}
+			}
+			if section == nil {
+				return nil, &ParseError{Line: i + 1, Msg: fmt.Sprintf("%s is outside of a section", key)}
+			}
+			line := &Line{Key: key, Value: strings.TrimSpace(stripped[eq+1:]), Comment: strings.TrimSpace(comment), raw: raw, parsed: true}
+			line.parsedKey, line.parsedValue, line.parsedComment = line.Key, line.Value, line.Comment
+			section.Lines = append(section.Lines, line)
+		}
+	}
+	return c, nil
+}
+
+// Bytes serialises the config. Unchanged lines are written as they were
+// parsed, including their line endings.
+func (c *Config) Bytes() []byte {
+	var buf bytes.Buffer
+	lines := []string{}
+	for _, line := range c.Preamble {
+		lines = append(lines, line.text(c.crlf))
+	}
+	for _, section := range c.Sections {
+		lines = append(lines, section.headerText(c.crlf))
+		for _, line := range section.Lines {
+			lines = append(lines, line.text(c.crlf))
+		}
+	}
+	buf.WriteString(strings.Join(lines, "\n"))
+	if len(lines) > 0 && !c.noFinalNewline {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+func (c *Config) String() string {
+	return string(c.Bytes())
+}
+
+// AddComment appends a comment line before the first section.
+func (c *Config) AddComment(text string) {
+	c.Preamble = append(c.Preamble, &Line{Comment: "# " + text})
+}
+
+// Interface returns the first [Interface] section, nil when there is none.
+func (c *Config) Interface() *Section {
+	for _, section := range c.Sections {
+		if strings.EqualFold(section.Name, SectionInterface) {
+			return section
+		}
+	}
+	return nil
+}
+
+// Peers returns the [Peer] sections in order.
+func (c *Config) Peers() []*Section {
+	peers := []*Section{}
+	for _, section := range c.Sections {
+		if strings.EqualFold(section.Name, SectionPeer) {
+			peers = append(peers, section)
+		}
+	}
+	return peers
+}
+
+// Peer returns the peer with publicKey, nil when there is none.
+func (c *Config) Peer(publicKey string) *Section {
+	for _, peer := range c.Peers() {
+		if key, _ := peer.Get(KeyPublicKey); key == publicKey {
+			return peer
+		}
+	}
+	return nil
+}
+
+// AddSection appends a section, separated from the previous one by a blank
+// line.
+func (c *Config) AddSection(name string) *Section {
+	if n := len(c.Sections); n > 0 {
+		last := c.Sections[n-1]
+		if len(last.Lines) == 0 || last.Lines[len(last.Lines)-1].String() != "" {
+			last.Lines = append(last.Lines, &Line{})
+		}
+	}
+	section := &Section{Name: name}
+	c.Sections = append(c.Sections, section)
+	return section
+}
+
+// RemovePeer removes the peer with publicKey and reports whether it existed.
+func (c *Config) RemovePeer(publicKey string) bool {
+	peer := c.Peer(publicKey)
+	if peer == nil {
+		return false
+	}
+	sections := c.Sections[:0]
+	for _, section := range c.Sections {
+		if section != peer {
+			sections = append(sections, section)
+		}
+	}
+	c.Sections = sections
+	return true
+}
+
+func splitList(value string) []string {
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
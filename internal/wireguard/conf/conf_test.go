@@ -0,0 +1,287 @@
+package conf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+const (
+	privateKey   = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	publicKey    = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	publicKey2   = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+	presharedKey = "gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA="
+)
+
+const sample = `# Managed by hand
+[Interface]
+PrivateKey = ` + privateKey + `
+Address = 10.8.0.2/32, fd00::2/128 # both families
+DNS = 10.8.0.1
+
+[Peer]
+# office
+PublicKey = ` + publicKey + `
+AllowedIPs = 10.0.0.0/8
+Endpoint = vpn.example.com:51820
+
+[Peer]
+PublicKey = ` + publicKey2 + `
+AllowedIPs = 192.168.10.0/24
+`
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"\n",
+		sample,
+		strings.ReplaceAll(sample, "\n", "\r\n"),
+		strings.TrimSuffix(sample, "\n"),
+		"[Interface]\r\nPrivateKey=x\n\n",
+		"[Interface]\nFoo = bar # baz\n  # indented\n\t\n",
+		"[ Peer ] # comment\nAllowedIPs = a,b,,c\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, err := Parse(data)
+		if err != nil {
+			return
+		}
+		if out := c.Bytes(); !bytes.Equal(out, data) {
+			t.Errorf("Parse(%q).Bytes() = %q", data, out)
+		}
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, input := range map[string]string{
+		"sample":            sample,
+		"crlf":              strings.ReplaceAll(sample, "\n", "\r\n"),
+		"no final newline":  strings.TrimSuffix(sample, "\n"),
+		"crlf without last": strings.TrimSuffix(strings.ReplaceAll(sample, "\n", "\r\n"), "\r\n"),
+		"trailing comments": sample + "\n# the end\n  # indented too\n",
+		"unknown keys":      "[Interface]\nPrivateKey = " + privateKey + "\nJc = 4\nI1 = <b 0x01>\n[Custom]\nfoo=bar\n",
+		"odd spacing":       "[Interface]\n\tPrivateKey=" + privateKey + "   \nAddress   =   10.8.0.2/32#note\n",
+		"empty":             "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := Parse([]byte(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out := c.String(); out != input {
+				t.Errorf("got:\n%q\nwant:\n%q", out, input)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := c.Interface()
+	if iface == nil {
+		t.Fatal("no [Interface]")
+	}
+	if got := iface.List(KeyAddress); strings.Join(got, " ") != "10.8.0.2/32 fd00::2/128" {
+		t.Errorf("Address = %v", got)
+	}
+	if got, _ := iface.Get("address"); got != "10.8.0.2/32, fd00::2/128" {
+		t.Errorf("keys are not matched without case: %q", got)
+	}
+	if peers := c.Peers(); len(peers) != 2 {
+		t.Fatalf("%d peers", len(peers))
+	}
+	if endpoint, _ := c.Peer(publicKey).Get(KeyEndpoint); endpoint != "vpn.example.com:51820" {
+		t.Errorf("Endpoint = %q", endpoint)
+	}
+	if c.Peer("missing") != nil {
+		t.Error("found a missing peer")
+	}
+	if comment := iface.Lines[1].Comment; comment != "# both families" {
+		t.Errorf("trailing comment %q", comment)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"unterminated header": "[Interface\n",
+		"empty section":       "[ ]\n",
+		"no equals":           "[Interface]\nPrivateKey\n",
+		"no key":              "[Interface]\n= value\n",
+		"outside section":     "PrivateKey = x\n[Interface]\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(input))
+			parseErr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("got %v, want a ParseError", err)
+			}
+			if parseErr.Line != 1 && name != "no equals" && name != "no key" {
+				t.Errorf("line %d", parseErr.Line)
+			}
+		})
+	}
+}
+
+func TestEdits(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		edit  func(c *Config)
+		want  string
+	}{
+		{
+			name:  "set keeps position and comment",
+			input: "[Interface]\nAddress = 10.8.0.2/32 # tunnel\nMTU = 1420\n",
+			edit:  func(c *Config) { c.Interface().Set("address", "10.9.0.2/32") },
+			want:  "[Interface]\nAddress = 10.9.0.2/32 # tunnel\nMTU = 1420\n",
+		},
+		{
+			name:  "set removes duplicates",
+			input: "[Interface]\nDNS = 1.1.1.1\nMTU = 1420\nDNS = 8.8.8.8\n",
+			edit:  func(c *Config) { c.Interface().Set(KeyDNS, "9.9.9.9") },
+			want:  "[Interface]\nDNS = 9.9.9.9\nMTU = 1420\n",
+		},
+		{
+			name:  "set adds a missing key after the last key",
+			input: "[Interface]\nMTU = 1420\n\n# peers\n[Peer]\n",
+			edit:  func(c *Config) { c.Interface().Set(KeyTable, "off") },
+			want:  "[Interface]\nMTU = 1420\nTable = off\n\n# peers\n[Peer]\n",
+		},
+		{
+			name:  "add keeps the line ending",
+			input: "[Interface]\r\nMTU = 1420\r\n",
+			edit:  func(c *Config) { c.Interface().Add(KeyPostUp, "true") },
+			want:  "[Interface]\r\nMTU = 1420\r\nPostUp = true\r\n",
+		},
+		{
+			name:  "delete",
+			input: "[Interface]\nPostUp = a\nMTU = 1420 # keep\nPostUp = b\n",
+			edit:  func(c *Config) { c.Interface().Delete("postup") },
+			want:  "[Interface]\nMTU = 1420 # keep\n",
+		},
+		{
+			name:  "unchanged value keeps its spacing",
+			input: "[Interface]\nMTU=1420\nTable=auto\n",
+			edit:  func(c *Config) { c.Interface().Set(KeyMTU, "1420"); c.Interface().Set(KeyTable, "off") },
+			want:  "[Interface]\nMTU=1420\nTable = off\n",
+		},
+		{
+			name:  "remove peer",
+			input: "[Interface]\n\n[Peer]\nPublicKey = " + publicKey + "\n\n[Peer]\nPublicKey = " + publicKey2 + "\n",
+			edit:  func(c *Config) { c.RemovePeer(publicKey) },
+			want:  "[Interface]\n\n[Peer]\nPublicKey = " + publicKey2 + "\n",
+		},
+		{
+			name:  "add section",
+			input: "[Interface]\nMTU = 1420\n",
+			edit:  func(c *Config) { c.AddSection(SectionPeer).Add(KeyPublicKey, publicKey) },
+			want:  "[Interface]\nMTU = 1420\n\n[Peer]\nPublicKey = " + publicKey + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := Parse([]byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.edit(c)
+			if out := c.String(); out != test.want {
+				t.Errorf("got:\n%q\nwant:\n%q", out, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	iface := "[Interface]\nPrivateKey = " + privateKey + "\n"
+	peer := "[Peer]\nPublicKey = " + publicKey + "\n"
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{name: "minimal", input: iface},
+		{name: "sample", input: sample},
+		{name: "full", input: iface + "ListenPort = 51820\nFwMark = 0x1234\nMTU = 1420\nTable = off\nSaveConfig = false\nPostUp = echo up\nPostUp = echo again\n" +
+			peer + "PresharedKey = " + presharedKey + "\nAllowedIPs = 0.0.0.0/0\nAllowedIPs = ::/0\nEndpoint = [2001:db8::1]:51820\nPersistentKeepalive = 25\n"},
+		{name: "keepalive off", input: iface + peer + "PersistentKeepalive = off\n"},
+		{name: "no interface", input: peer, err: "missing [Interface]"},
+		{name: "two interfaces", input: iface + iface, err: "more than one [Interface]"},
+		{name: "no private key", input: "[Interface]\nMTU = 1420\n", err: "no PrivateKey"},
+		{name: "bad private key", input: "[Interface]\nPrivateKey = c2hvcnQ=\n", err: "32 byte key"},
+		{name: "no public key", input: iface + "[Peer]\nAllowedIPs = 10.0.0.0/8\n", err: "no PublicKey"},
+		{name: "duplicate peer", input: iface + peer + peer, err: "more than once"},
+		{name: "unknown section", input: iface + "[Custom]\n", err: "unknown section [Custom]"},
+		{name: "unknown key", input: iface + "Jc = 4\n", err: "unknown key Jc"},
+		{name: "repeated key", input: iface + "MTU = 1420\nMTU = 1280\n", err: "sets MTU more than once"},
+		{name: "bad port", input: iface + "ListenPort = 70000\n", err: "invalid port"},
+		{name: "bad mtu", input: iface + "MTU = 100\n", err: "invalid MTU"},
+		{name: "bad fwmark", input: iface + "FwMark = mark\n", err: "invalid firewall mark"},
+		{name: "bad bool", input: iface + "SaveConfig = yes\n", err: "not true or false"},
+		{name: "bad address", input: iface + "Address = 10.8.0.300/32\n", err: "invalid address"},
+		{name: "bad allowed ip in list", input: iface + peer + "AllowedIPs = 10.0.0.0/8, nope\n", err: "invalid address \"nope\""},
+		{name: "endpoint without port", input: iface + peer + "Endpoint = vpn.example.com\n", err: "expected host:port"},
+		{name: "bad keepalive", input: iface + peer + "PersistentKeepalive = -1\n", err: "invalid keepalive"},
+		{name: "empty table", input: iface + "Table = \n", err: "empty value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := Parse([]byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Validate()
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestFromClientConfig(t *testing.T) {
+	clientConfig := models.WGClientConfig{
+		WGClientInterfaceConfig: models.WGClientInterfaceConfig{
+			ClientPrivateKey: privateKey,
+			AllowedIpAddress: "10.8.0.2/32",
+			DnsServer:        "10.8.0.1",
+		},
+		WGClientPeerConfig: models.WGClientPeerConfig{
+			ServerPublicKey: publicKey,
+			PresharedKey:    presharedKey,
+			AllowedIPs:      []string{"10.0.0.0/8"},
+			VpnGatewayIP:    "198.51.100.7",
+			VpnGatewayPort:  51820,
+			PersistantAlive: 25,
+		},
+	}
+	c := FromClientConfig(clientConfig, Options{Comment: "Generated", TableOff: true, AllowedIPs: []string{"0.0.0.0/0", "::/0"}})
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Generated\n[Interface]\nPrivateKey = " + privateKey + "\nAddress = 10.8.0.2/32\nTable = off\nDNS = 10.8.0.1\n\n" +
+		"[Peer]\nPublicKey = " + publicKey + "\nPresharedKey = " + presharedKey + "\nAllowedIPs = 0.0.0.0/0, ::/0\nEndpoint = 198.51.100.7:51820\nPersistentKeepalive = 25\n"
+	if out := c.String(); out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	parsed, err := Parse(c.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != want {
+		t.Error("generated config does not round trip")
+	}
+}
@@ -0,0 +1,55 @@
+package conf
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+// Options adjust the config generated from a gateway client.
+type Options struct {
+	// Comment is written above the first section.
+	Comment string
+	// AllowedIPs replace the ones sent by the gateway when set.
+	AllowedIPs []string
+	// OmitDNS leaves the resolver alone, for when DNS is set up elsewhere.
+	OmitDNS bool
+	// TableOff stops wg-quick from installing routes.
+	TableOff bool
+}
+
+// FromClientConfig builds the wg-quick config of a Qryptic gateway client.
+func FromClientConfig(clientConfig models.WGClientConfig, opts Options) *Config {
+	c := New()
+	if opts.Comment != "" {
+		c.AddComment(opts.Comment)
+	}
+
+	iface := c.AddSection(SectionInterface)
+	iface.Add(KeyPrivateKey, clientConfig.WGClientInterfaceConfig.ClientPrivateKey)
+	iface.Add(KeyAddress, strings.Join(clientConfig.WGClientInterfaceConfig.Addresses(), ", "))
+	if opts.TableOff {
+		iface.Add(KeyTable, "off")
+	}
+	if dns := clientConfig.WGClientInterfaceConfig.DnsServer; !opts.OmitDNS && dns != "" {
+		iface.Add(KeyDNS, dns)
+	}
+
+	peerConfig := clientConfig.WGClientPeerConfig
+	peer := c.AddSection(SectionPeer)
+	peer.Add(KeyPublicKey, peerConfig.ServerPublicKey)
+	if peerConfig.PresharedKey != "" {
+		peer.Add(KeyPresharedKey, peerConfig.PresharedKey)
+	}
+	allowedIPs := opts.AllowedIPs
+	if allowedIPs == nil {
+		allowedIPs = peerConfig.AllowedIPs
+	}
+	peer.Add(KeyAllowedIPs, strings.Join(allowedIPs, ", "))
+	peer.Add(KeyEndpoint, peerConfig.Endpoint())
+	if peerConfig.PersistantAlive > 0 {
+		peer.Add(KeyPersistentKeepalive, strconv.Itoa(peerConfig.PersistantAlive))
+	}
+	return c
+}
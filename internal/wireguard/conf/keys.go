@@ -0,0 +1,221 @@
+package conf
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Interface keys, those of wg(8) followed by the ones only wg-quick reads.
+const (
+	KeyPrivateKey = "PrivateKey"
+	KeyListenPort = "ListenPort"
+	KeyFwMark     = "FwMark"
+	KeyAddress    = "Address"
+	KeyDNS        = "DNS"
+	KeyMTU        = "MTU"
+	KeyTable      = "Table"
+	KeyPreUp      = "PreUp"
+	KeyPostUp     = "PostUp"
+	KeyPreDown    = "PreDown"
+	KeyPostDown   = "PostDown"
+	KeySaveConfig = "SaveConfig"
+)
+
+// Peer keys.
+const (
+	KeyPublicKey           = "PublicKey"
+	KeyPresharedKey        = "PresharedKey"
+	KeyAllowedIPs          = "AllowedIPs"
+	KeyEndpoint            = "Endpoint"
+	KeyPersistentKeepalive = "PersistentKeepalive"
+)
+
+// keySpec describes how a key is checked. Repeatable keys may occur more
+// than once in a section, their values add up.
+type keySpec struct {
+	name       string
+	repeatable bool
+	validate   func(value string) error
+}
+
+var interfaceKeys = []keySpec{
+	{name: KeyPrivateKey, validate: validateKey},
+	{name: KeyListenPort, validate: validatePort},
+	{name: KeyFwMark, validate: validateFwMark},
+	{name: KeyAddress, repeatable: true, validate: validateList(validateAddress)},
+	{name: KeyDNS, repeatable: true, validate: validateList(validateNonEmpty)},
+	{name: KeyMTU, validate: validateMTU},
+	{name: KeyTable, validate: validateNonEmpty},
+	{name: KeyPreUp, repeatable: true, validate: validateNonEmpty},
+	{name: KeyPostUp, repeatable: true, validate: validateNonEmpty},
+	{name: KeyPreDown, repeatable: true, validate: validateNonEmpty},
+	{name: KeyPostDown, repeatable: true, validate: validateNonEmpty},
+	{name: KeySaveConfig, validate: validateBool},
+}
+
+var peerKeys = []keySpec{
+	{name: KeyPublicKey, validate: validateKey},
+	{name: KeyPresharedKey, validate: validateKey},
+	{name: KeyAllowedIPs, repeatable: true, validate: validateList(validateAddress)},
+	{name: KeyEndpoint, validate: validateEndpoint},
+	{name: KeyPersistentKeepalive, validate: validateKeepalive},
+}
+
+func lookupKey(specs []keySpec, key string) (keySpec, bool) {
+	for _, spec := range specs {
+		if strings.EqualFold(spec.name, key) {
+			return spec, true
+		}
+	}
+	return keySpec{}, false
+}
+
+// Validate checks the config the way wg-quick and wg setconf would: one
+// [Interface] with a private key, peers with distinct public keys and only
+// known sections and keys with valid values. Unknown keys are kept by Parse
+// and Bytes but reported here, stock wg-quick refuses them.
+func (c *Config) Validate() error {
+	errs := []error{}
+	interfaces := 0
+	publicKeys := map[string]bool{}
+	for _, section := range c.Sections {
+		var specs []keySpec
+		switch {
+		case strings.EqualFold(section.Name, SectionInterface):
+			interfaces++
+			specs = interfaceKeys
+			if _, ok := section.Get(KeyPrivateKey); !ok {
+				errs = append(errs, errors.New("[Interface] has no PrivateKey"))
+			}
+		case strings.EqualFold(section.Name, SectionPeer):
+			specs = peerKeys
+			publicKey, ok := section.Get(KeyPublicKey)
+			if !ok {
+				errs = append(errs, errors.New("[Peer] has no PublicKey"))
+			} else if publicKeys[publicKey] {
+				errs = append(errs, fmt.Errorf("peer %s appears more than once", publicKey))
+			}
+			publicKeys[publicKey] = true
+		default:
+			errs = append(errs, fmt.Errorf("unknown section [%s]", section.Name))
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, line := range section.Lines {
+			if !line.IsKey() {
+				continue
+			}
+			spec, ok := lookupKey(specs, line.Key)
+			if !ok {
+				errs = append(errs, fmt.Errorf("[%s] has unknown key %s", section.Name, line.Key))
+				continue
+			}
+			if seen[spec.name] && !spec.repeatable {
+				errs = append(errs, fmt.Errorf("[%s] sets %s more than once", section.Name, spec.name))
+			}
+			seen[spec.name] = true
+			if err := spec.validate(line.Value); err != nil {
+				errs = append(errs, fmt.Errorf("[%s] %s: %w", section.Name, spec.name, err))
+			}
+		}
+	}
+	if interfaces == 0 {
+		errs = append(errs, errors.New("missing [Interface] section"))
+	} else if interfaces > 1 {
+		errs = append(errs, errors.New("more than one [Interface] section"))
+	}
+	return errors.Join(errs...)
+}
+
+func validateKey(value string) error {
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != 32 {
+		return errors.New("not a base64 encoded 32 byte key")
+	}
+	return nil
+}
+
+func validatePort(value string) error {
+	if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", value)
+	}
+	return nil
+}
+
+func validateFwMark(value string) error {
+	if value == "off" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(value, 0, 32); err != nil {
+		return fmt.Errorf("invalid firewall mark %q", value)
+	}
+	return nil
+}
+
+func validateMTU(value string) error {
+	mtu, err := strconv.Atoi(value)
+	if err != nil || mtu < 576 || mtu > 65535 {
+		return fmt.Errorf("invalid MTU %q", value)
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if value != "true" && value != "false" {
+		return fmt.Errorf("%q is not true or false", value)
+	}
+	return nil
+}
+
+func validateKeepalive(value string) error {
+	if value == "off" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+		return fmt.Errorf("invalid keepalive interval %q", value)
+	}
+	return nil
+}
+
+// validateAddress accepts a network or a single address, as wg does.
+func validateAddress(value string) error {
+	if _, err := netip.ParsePrefix(value); err == nil {
+		return nil
+	}
+	if _, err := netip.ParseAddr(value); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid address %q", value)
+}
+
+func validateEndpoint(value string) error {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid endpoint %q, expected host:port", value)
+	}
+	return validatePort(port)
+}
+
+func validateNonEmpty(value string) error {
+	if value == "" {
+		return errors.New("empty value")
+	}
+	return nil
+}
+
+func validateList(validate func(string) error) func(string) error {
+	return func(value string) error {
+		for _, item := range splitList(value) {
+			if err := validate(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
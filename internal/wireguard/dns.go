@@ -0,0 +1,361 @@
+package wireguard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// DNSBackend identifies the mechanism used to configure the system resolver.
+type DNSBackend string
+
+const (
+	DNSBackendNone            DNSBackend = ""
+	DNSBackendSystemdResolved DNSBackend = "systemd-resolved"
+	DNSBackendResolvconf      DNSBackend = "resolvconf"
+	DNSBackendResolvConfFile  DNSBackend = "resolv.conf"
+)
+
+const defaultResolvConfPath = "/etc/resolv.conf"
+
+// defaultResolvedUpstreamPath lists the servers systemd-resolved forwards to,
+// where /etc/resolv.conf only names its local stub.
+const defaultResolvedUpstreamPath = "/run/systemd/resolve/resolv.conf"
+
+// CommandRunner runs name with args, feeding it stdin when that is not
+// empty, and returns the combined output.
+type CommandRunner func(stdin, name string, args ...string) ([]byte, error)
+
+func runCommand(stdin, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	return cmd.CombinedOutput()
+}
+
+// dnsState records what was changed so that it can be undone, even by a later
+// process after the one that applied it has crashed.
+type dnsState struct {
+	Backend        DNSBackend `json:"backend"`
+	Interface      string     `json:"interface"`
+	ResolvConf     string     `json:"resolvConf,omitempty"`
+	ResolvConfLink string     `json:"resolvConfLink,omitempty"`
+	ResolvConfMode uint32     `json:"resolvConfMode,omitempty"`
+}
+
+// DNSManager configures per-interface DNS servers and routing domains for the
+// tunnel and restores the previous resolver configuration afterwards.
+type DNSManager struct {
+	StatePath string
+	Backend   DNSBackend
+	// The resolver files and the runner of resolvectl and resolvconf, set
+	// to the system ones by NewDNSManager.
+	ResolvConfPath       string
+	ResolvedUpstreamPath string
+	Run                  CommandRunner
+}
+
+// NewDNSManager initializes a new DNSManager, detecting the resolver backend in use.
+func NewDNSManager(stateDir string) *DNSManager {
+	return &DNSManager{
+		StatePath:            filepath.Join(stateDir, "qryptic-dns.json"),
+		Backend:              DetectDNSBackend(),
+		ResolvConfPath:       defaultResolvConfPath,
+		ResolvedUpstreamPath: defaultResolvedUpstreamPath,
+		Run:                  runCommand,
+	}
+}
+
+// DetectDNSBackend returns the resolver backend available on this system.
+// Platforms other than Linux are left to wg-quick.
+func DetectDNSBackend() DNSBackend {
+	if runtime.GOOS != "linux" {
+		return DNSBackendNone
+	}
+	if _, err := exec.LookPath("resolvectl"); err == nil {
+		if exec.Command("systemctl", "is-active", "--quiet", "systemd-resolved").Run() == nil {
+			return DNSBackendSystemdResolved
+		}
+	}
+	if _, err := exec.LookPath("resolvconf"); err == nil {
+		return DNSBackendResolvconf
+	}
+	return DNSBackendResolvConfFile
+}
+
+// Managed reports whether DNS is configured by the manager instead of wg-quick.
+func (d *DNSManager) Managed() bool {
+	return d != nil && d.Backend != DNSBackendNone
+}
+
+// Apply points the resolver at servers for the tunnel interface. When domains
+// is empty all queries are sent through the tunnel, otherwise only queries for
+// the given routing domains are.
+func (d *DNSManager) Apply(interfaceName string, servers, domains []string) error {
+	if !d.Managed() || len(servers) == 0 {
+		return nil
+	}
+	// Undo anything left behind by an earlier session before recording new state.
+	if err := d.Restore(); err != nil {
+		return err
+	}
+
+	state := dnsState{Backend: d.Backend, Interface: interfaceName}
+	if d.Backend == DNSBackendResolvConfFile {
+		if err := d.snapshotResolvConf(&state); err != nil {
+			return err
+		}
+	}
+	if err := d.saveState(state); err != nil {
+		return err
+	}
+
+	var err error
+	switch d.Backend {
+	case DNSBackendSystemdResolved:
+		err = d.applySystemdResolved(interfaceName, servers, domains)
+	case DNSBackendResolvconf:
+		err = d.applyResolvconf(interfaceName, servers, domains)
+	case DNSBackendResolvConfFile:
+		err = d.writeResolvConf(state, renderResolvConf(servers, domains))
+	}
+	if err != nil {
+		d.Restore()
+		return fmt.Errorf("failed to configure DNS via %s: %w", d.Backend, err)
+	}
+	return nil
+}
+
+// ApplyStub sends the queries for domains to the local DNS stub at stubAddr.
+// systemd-resolved routes only those domains to the stub, the other backends
+// have no routing domains and send every query to it, which it forwards.
+func (d *DNSManager) ApplyStub(interfaceName, stubAddr string, domains []string) error {
+	if !d.Managed() {
+		return fmt.Errorf("the system resolver cannot be pointed at the split DNS stub on %s", runtime.GOOS)
+	}
+	host, port, err := net.SplitHostPort(stubAddr)
+	if err != nil {
+		return fmt.Errorf("invalid split DNS listen address %s: %w", stubAddr, err)
+	}
+	if d.Backend == DNSBackendSystemdResolved {
+		return d.Apply(interfaceName, []string{stubAddr}, domains)
+	}
+	if port != "53" {
+		return fmt.Errorf("%s only supports DNS servers on port 53, the split DNS stub listens on %s", d.Backend, stubAddr)
+	}
+	return d.Apply(interfaceName, []string{host}, nil)
+}
+
+// Restore reverts the resolver to the state recorded by Apply. It is a no-op
+// when nothing has been applied.
+func (d *DNSManager) Restore() error {
+	if d == nil {
+		return nil
+	}
+	data, err := os.ReadFile(d.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read DNS state: %w", err)
+	}
+	var state dnsState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse DNS state: %w", err)
+	}
+
+	switch state.Backend {
+	case DNSBackendSystemdResolved:
+		// The link may already be gone together with the interface.
+		d.Run("", "resolvectl", "revert", state.Interface)
+	case DNSBackendResolvconf:
+		d.Run("", "resolvconf", "-d", resolvconfRecord(state.Interface), "-f")
+	case DNSBackendResolvConfFile:
+		if err := d.restoreResolvConf(state); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(d.StatePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove DNS state: %w", err)
+	}
+	return nil
+}
+
+func (d *DNSManager) saveState(state dnsState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.StatePath), 0700); err != nil {
+		return fmt.Errorf("failed to create DNS state directory: %w", err)
+	}
+	if err := os.WriteFile(d.StatePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write DNS state: %w", err)
+	}
+	return nil
+}
+
+func (d *DNSManager) applySystemdResolved(interfaceName string, servers, domains []string) error {
+	args := append([]string{"dns", interfaceName}, servers...)
+	if output, err := d.Run("", "resolvectl", args...); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// "~" marks a routing-only domain; "~." routes every query to this link.
+	routingDomains := []string{"~."}
+	defaultRoute := "true"
+	if len(domains) > 0 {
+		routingDomains = []string{}
+		for _, domain := range domains {
+			routingDomains = append(routingDomains, "~"+strings.TrimPrefix(domain, "~"))
+		}
+		defaultRoute = "false"
+	}
+	args = append([]string{"domain", interfaceName}, routingDomains...)
+	if output, err := d.Run("", "resolvectl", args...); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if output, err := d.Run("", "resolvectl", "default-route", interfaceName, defaultRoute); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (d *DNSManager) applyResolvconf(interfaceName string, servers, domains []string) error {
+	// resolvconf has no notion of routing domains, so they become search domains.
+	output, err := d.Run(renderResolvConf(servers, domains), "resolvconf", "-a", resolvconfRecord(interfaceName), "-m", "0", "-x")
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func resolvconfRecord(interfaceName string) string {
+	return "tun." + interfaceName
+}
+
+func renderResolvConf(servers, domains []string) string {
+	var b strings.Builder
+	b.WriteString("# Generated by qryptic. The previous file is restored on disconnect.\n")
+	for _, server := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+	if len(domains) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(domains, " "))
+	}
+	return b.String()
+}
+
+func (d *DNSManager) writeResolvConf(state dnsState, content string) error {
+	if state.ResolvConfLink != "" {
+		// Replace the link with a regular file so the target is never modified.
+		if err := os.Remove(d.ResolvConfPath); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(d.ResolvConfPath, []byte(content), 0644)
+}
+
+func (d *DNSManager) snapshotResolvConf(state *dnsState) error {
+	info, err := os.Lstat(d.ResolvConfPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", d.ResolvConfPath, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(d.ResolvConfPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", d.ResolvConfPath, err)
+		}
+		state.ResolvConfLink = target
+		return nil
+	}
+	data, err := os.ReadFile(d.ResolvConfPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", d.ResolvConfPath, err)
+	}
+	state.ResolvConf = string(data)
+	state.ResolvConfMode = uint32(info.Mode().Perm())
+	return nil
+}
+
+func (d *DNSManager) restoreResolvConf(state dnsState) error {
+	if state.ResolvConfLink != "" {
+		os.Remove(d.ResolvConfPath)
+		if err := os.Symlink(state.ResolvConfLink, d.ResolvConfPath); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", d.ResolvConfPath, err)
+		}
+		return nil
+	}
+	mode := os.FileMode(state.ResolvConfMode)
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := os.WriteFile(d.ResolvConfPath, []byte(state.ResolvConf), mode); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", d.ResolvConfPath, err)
+	}
+	return os.Chmod(d.ResolvConfPath, mode)
+}
+
+// UpstreamServers returns the resolvers the system used before the tunnel
+// changed anything, the servers that have to stay reachable when the tunnel
+// is down. Loopback stubs are left out.
+func (d *DNSManager) UpstreamServers() []net.IP {
+	var content string
+	if d.Backend == DNSBackendSystemdResolved {
+		if data, err := os.ReadFile(d.ResolvedUpstreamPath); err == nil {
+			content = string(data)
+		}
+	}
+	// While applied the file names the tunnel DNS, the state holds the original
+	if data, err := os.ReadFile(d.StatePath); content == "" && err == nil {
+		var state dnsState
+		if json.Unmarshal(data, &state) == nil && state.Backend == DNSBackendResolvConfFile {
+			content = state.ResolvConf
+			if target := state.ResolvConfLink; target != "" {
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(filepath.Dir(d.ResolvConfPath), target)
+				}
+				if data, err := os.ReadFile(target); err == nil {
+					content = string(data)
+				}
+			}
+		}
+	}
+	if content == "" {
+		if data, err := os.ReadFile(d.ResolvConfPath); err == nil {
+			content = string(data)
+		}
+	}
+
+	servers := []net.IP{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		// Strip an IPv6 zone, nftables matches addresses only
+		ip := net.ParseIP(strings.SplitN(fields[1], "%", 2)[0])
+		if ip != nil && !ip.IsLoopback() {
+			servers = append(servers, ip)
+		}
+	}
+	return servers
+}
+
+// parseDNSServers splits the comma separated server list sent by the controller.
+func parseDNSServers(dnsServer string) []string {
+	servers := []string{}
+	for _, server := range strings.Split(dnsServer, ",") {
+		server = strings.TrimSpace(server)
+		if server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
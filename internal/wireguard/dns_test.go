@@ -0,0 +1,246 @@
+package wireguard
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const originalResolvConf = "nameserver 192.168.1.1\nsearch home.example\n"
+
+// recordedCommand is one command run through a DNSManager.
+type recordedCommand struct {
+	stdin string
+	args  string
+}
+
+// newTestDNSManager returns a manager for backend whose files live in a
+// temporary directory and whose commands are recorded instead of run.
+func newTestDNSManager(t *testing.T, backend DNSBackend) (*DNSManager, *[]recordedCommand) {
+	t.Helper()
+	dir := t.TempDir()
+	d := &DNSManager{
+		StatePath:            filepath.Join(dir, "state", "qryptic-dns.json"),
+		Backend:              backend,
+		ResolvConfPath:       filepath.Join(dir, "resolv.conf"),
+		ResolvedUpstreamPath: filepath.Join(dir, "resolved-upstream.conf"),
+	}
+	if err := os.WriteFile(d.ResolvConfPath, []byte(originalResolvConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commands := &[]recordedCommand{}
+	d.Run = func(stdin, name string, args ...string) ([]byte, error) {
+		*commands = append(*commands, recordedCommand{stdin: stdin, args: strings.Join(append([]string{name}, args...), " ")})
+		return nil, nil
+	}
+	return d, commands
+}
+
+// reopen returns a manager over the same files, as a later process sees them.
+func reopen(d *DNSManager) *DNSManager {
+	reopened := *d
+	return &reopened
+}
+
+func commandArgs(commands []recordedCommand) []string {
+	args := []string{}
+	for _, command := range commands {
+		args = append(args, command.args)
+	}
+	return args
+}
+
+func stateExists(d *DNSManager) bool {
+	_, err := os.Stat(d.StatePath)
+	return err == nil
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDNSSystemdResolved(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		want    []string
+	}{
+		{name: "full tunnel", want: []string{
+			"resolvectl dns wg0 10.8.0.1 10.8.0.2",
+			"resolvectl domain wg0 ~.",
+			"resolvectl default-route wg0 true",
+		}},
+		{name: "routing domains", domains: []string{"corp.example", "~lab.example"}, want: []string{
+			"resolvectl dns wg0 10.8.0.1 10.8.0.2",
+			"resolvectl domain wg0 ~corp.example ~lab.example",
+			"resolvectl default-route wg0 false",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, commands := newTestDNSManager(t, DNSBackendSystemdResolved)
+			if err := d.Apply("wg0", []string{"10.8.0.1", "10.8.0.2"}, tt.domains); err != nil {
+				t.Fatal(err)
+			}
+			if got := commandArgs(*commands); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commands %q, want %q", got, tt.want)
+			}
+			if readFile(t, d.ResolvConfPath) != originalResolvConf {
+				t.Error("resolv.conf changed")
+			}
+
+			*commands = nil
+			if err := d.Restore(); err != nil {
+				t.Fatal(err)
+			}
+			if got := commandArgs(*commands); !reflect.DeepEqual(got, []string{"resolvectl revert wg0"}) {
+				t.Errorf("restore commands %q", got)
+			}
+			if stateExists(d) {
+				t.Error("state kept after restore")
+			}
+		})
+	}
+}
+
+func TestDNSResolvconf(t *testing.T) {
+	d, commands := newTestDNSManager(t, DNSBackendResolvconf)
+	if err := d.Apply("wg0", []string{"10.8.0.1"}, []string{"corp.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*commands) != 1 || (*commands)[0].args != "resolvconf -a tun.wg0 -m 0 -x" {
+		t.Fatalf("commands %q", commandArgs(*commands))
+	}
+	if stdin := (*commands)[0].stdin; !strings.Contains(stdin, "nameserver 10.8.0.1\n") || !strings.Contains(stdin, "search corp.example\n") {
+		t.Errorf("resolvconf record:\n%s", stdin)
+	}
+
+	*commands = nil
+	if err := d.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if got := commandArgs(*commands); !reflect.DeepEqual(got, []string{"resolvconf -d tun.wg0 -f"}) {
+		t.Errorf("restore commands %q", got)
+	}
+}
+
+func TestDNSResolvConfFile(t *testing.T) {
+	d, commands := newTestDNSManager(t, DNSBackendResolvConfFile)
+	if err := d.Apply("wg0", []string{"10.8.0.1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(*commands) != 0 {
+		t.Errorf("commands run for the plain file: %q", commandArgs(*commands))
+	}
+	if content := readFile(t, d.ResolvConfPath); !strings.Contains(content, "nameserver 10.8.0.1\n") || strings.Contains(content, "192.168.1.1") {
+		t.Errorf("resolv.conf while applied:\n%s", content)
+	}
+	// The resolvers in use before the tunnel, not the tunnel's
+	if servers := d.UpstreamServers(); len(servers) != 1 || servers[0].String() != "192.168.1.1" {
+		t.Errorf("upstream servers %v", servers)
+	}
+
+	if err := d.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFile(t, d.ResolvConfPath); content != originalResolvConf {
+		t.Errorf("resolv.conf not restored:\n%s", content)
+	}
+	info, err := os.Stat(d.ResolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("resolv.conf mode %#o", info.Mode().Perm())
+	}
+	if stateExists(d) {
+		t.Error("state kept after restore")
+	}
+}
+
+func TestDNSResolvConfSymlink(t *testing.T) {
+	d, _ := newTestDNSManager(t, DNSBackendResolvConfFile)
+	target := filepath.Join(filepath.Dir(d.ResolvConfPath), "managed-resolv.conf")
+	if err := os.Rename(d.ResolvConfPath, target); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, d.ResolvConfPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Apply("wg0", []string{"10.8.0.1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, target) != originalResolvConf {
+		t.Error("symlink target modified")
+	}
+	if err := d.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(d.ResolvConfPath); err != nil || link != target {
+		t.Errorf("symlink not restored: %q, %v", link, err)
+	}
+}
+
+func TestDNSRestoreAfterCrash(t *testing.T) {
+	for _, backend := range []DNSBackend{DNSBackendSystemdResolved, DNSBackendResolvconf, DNSBackendResolvConfFile} {
+		t.Run(string(backend), func(t *testing.T) {
+			crashed, commands := newTestDNSManager(t, backend)
+			if err := crashed.Apply("wg0", []string{"10.8.0.1"}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			// The next session applies again without the first having restored.
+			d := reopen(crashed)
+			*commands = nil
+			if err := d.Apply("wg1", []string{"10.9.0.1"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			revert := map[DNSBackend]string{
+				DNSBackendSystemdResolved: "resolvectl revert wg0",
+				DNSBackendResolvconf:      "resolvconf -d tun.wg0 -f",
+			}[backend]
+			if got := commandArgs(*commands); revert != "" && (len(got) == 0 || got[0] != revert) {
+				t.Errorf("crashed session not reverted first: %q", got)
+			}
+
+			if err := reopen(d).Restore(); err != nil {
+				t.Fatal(err)
+			}
+			// The backup is the file from before the crashed session.
+			if content := readFile(t, d.ResolvConfPath); content != originalResolvConf {
+				t.Errorf("resolv.conf after restore:\n%s", content)
+			}
+			if stateExists(d) {
+				t.Error("state kept after restore")
+			}
+		})
+	}
+}
+
+func TestDNSApplyFailureRestores(t *testing.T) {
+	d, _ := newTestDNSManager(t, DNSBackendSystemdResolved)
+	reverted := false
+	d.Run = func(stdin, name string, args ...string) ([]byte, error) {
+		if args[0] == "revert" {
+			reverted = true
+			return nil, nil
+		}
+		return []byte("Failed to set DNS configuration"), errors.New("exit status 1")
+	}
+	err := d.Apply("wg0", []string{"10.8.0.1"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Failed to set DNS configuration") {
+		t.Fatalf("Apply error %v", err)
+	}
+	if !reverted || stateExists(d) {
+		t.Errorf("failed apply left state behind, reverted %v", reverted)
+	}
+}
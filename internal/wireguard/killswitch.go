@@ -0,0 +1,205 @@
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+const killSwitchTable = "qryptic_killswitch"
+
+// Private and link-local ranges allowed when the kill switch permits LAN access.
+var lanRangesV4 = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16"}
+var lanRangesV6 = []string{"fe80::/10", "fc00::/7"}
+
+// lookupIP resolves host names, replaced in tests.
+var lookupIP = net.LookupIP
+
+// controlPlane is what connect has to reach before the tunnel is up: the
+// controller and the resolvers used to look it up.
+type controlPlane struct {
+	controller     []net.IP
+	controllerPort int
+	resolvers      []net.IP
+}
+
+// EnableKillSwitch installs nftables rules that drop all traffic except
+// through the tunnel interface, to the gateway endpoint and on loopback. The
+// controller at baseUrl and the system resolvers stay reachable, so that a
+// reconnect can fetch gateways and configs while the tunnel is down.
+// Calling it again atomically replaces the rules, e.g. for a new gateway.
+func (wg *WireGuardManager) EnableKillSwitch(clientConfig models.WGClientConfig, allowLAN bool, baseUrl string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("kill switch is not supported on %s", runtime.GOOS)
+	}
+	if err := checkBinary("nft"); err != nil {
+		return fmt.Errorf("kill switch requires nftables: %w", err)
+	}
+	endpoints, err := resolveEndpoint(clientConfig.WGClientPeerConfig)
+	if err != nil {
+		return err
+	}
+	control, err := resolveControlPlane(baseUrl)
+	if err != nil {
+		return err
+	}
+	control.resolvers = wg.DNS.UpstreamServers()
+
+	ruleset := renderKillSwitchRules(wg.TunnelInterface(), endpoints, clientConfig.WGClientPeerConfig.VpnGatewayPort, control, allowLAN)
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(ruleset)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to install kill switch rules: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// resolveEndpoint returns the addresses of the gateway. A gateway given only
+// by domain is resolved here and every address is allowed, wg-quick may pick
+// any of them when it brings the tunnel up.
+func resolveEndpoint(peer models.WGClientPeerConfig) ([]net.IP, error) {
+	if peer.VpnGatewayIP != "" {
+		endpoint := net.ParseIP(peer.VpnGatewayIP)
+		if endpoint == nil {
+			return nil, fmt.Errorf("invalid gateway endpoint IP: %q", peer.VpnGatewayIP)
+		}
+		return []net.IP{endpoint}, nil
+	}
+	if peer.VpnGatewayDomain == "" {
+		return nil, fmt.Errorf("the gateway has no endpoint")
+	}
+	ips, err := lookupIP(peer.VpnGatewayDomain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the gateway %s for the kill switch: %w", peer.VpnGatewayDomain, err)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("the gateway %s has no addresses", peer.VpnGatewayDomain)
+	}
+	return ips, nil
+}
+
+// resolveControlPlane looks up the addresses and port of the controller.
+func resolveControlPlane(baseUrl string) (controlPlane, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil || u.Hostname() == "" {
+		return controlPlane{}, fmt.Errorf("invalid controller URL: %q", baseUrl)
+	}
+	port := 443
+	if u.Scheme == "http" {
+		port = 80
+	}
+	if u.Port() != "" {
+		if port, err = strconv.Atoi(u.Port()); err != nil {
+			return controlPlane{}, fmt.Errorf("invalid controller URL: %q", baseUrl)
+		}
+	}
+	ips, err := lookupIP(u.Hostname())
+	if err != nil {
+		return controlPlane{}, fmt.Errorf("failed to resolve the controller for the kill switch: %w", err)
+	}
+	return controlPlane{controller: ips, controllerPort: port}, nil
+}
+
+// DisableKillSwitch removes the kill switch rules, if installed.
+func (wg *WireGuardManager) DisableKillSwitch() error {
+	if !wg.IsKillSwitchEnabled() {
+		return nil
+	}
+	output, err := exec.Command("nft", "delete", "table", "inet", killSwitchTable).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove kill switch rules: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// IsKillSwitchEnabled reports whether the kill switch rules are installed.
+func (wg *WireGuardManager) IsKillSwitchEnabled() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	return exec.Command("nft", "list", "table", "inet", killSwitchTable).Run() == nil
+}
+
+func renderKillSwitchRules(interfaceName string, endpoints []net.IP, endpointPort int, control controlPlane, allowLAN bool) string {
+	var b strings.Builder
+	// Creating the table before deleting it makes the replacement a single
+	// atomic transaction that also works when the table does not exist yet.
+	fmt.Fprintf(&b, "table inet %s\n", killSwitchTable)
+	fmt.Fprintf(&b, "delete table inet %s\n", killSwitchTable)
+	fmt.Fprintf(&b, "table inet %s {\n", killSwitchTable)
+
+	b.WriteString("\tchain output {\n")
+	b.WriteString("\t\ttype filter hook output priority 0; policy drop;\n")
+	b.WriteString("\t\toifname \"lo\" accept\n")
+	fmt.Fprintf(&b, "\t\toifname %q accept\n", interfaceName)
+	for _, set := range splitFamilies(endpoints) {
+		fmt.Fprintf(&b, "\t\t%s daddr { %s } udp dport %d accept\n", set.family, set.elements, endpointPort)
+	}
+	for _, set := range splitFamilies(control.controller) {
+		fmt.Fprintf(&b, "\t\t%s daddr { %s } tcp dport %d accept\n", set.family, set.elements, control.controllerPort)
+	}
+	for _, set := range splitFamilies(control.resolvers) {
+		fmt.Fprintf(&b, "\t\t%s daddr { %s } meta l4proto { tcp, udp } th dport 53 accept\n", set.family, set.elements)
+	}
+	if allowLAN {
+		fmt.Fprintf(&b, "\t\tip daddr { %s } accept\n", strings.Join(lanRangesV4, ", "))
+		fmt.Fprintf(&b, "\t\tip6 daddr { %s } accept\n", strings.Join(lanRangesV6, ", "))
+	}
+	b.WriteString("\t}\n")
+
+	b.WriteString("\tchain input {\n")
+	b.WriteString("\t\ttype filter hook input priority 0; policy drop;\n")
+	b.WriteString("\t\tiifname \"lo\" accept\n")
+	// Replies to the controller and resolver traffic allowed above
+	b.WriteString("\t\tct state established,related accept\n")
+	fmt.Fprintf(&b, "\t\tiifname %q accept\n", interfaceName)
+	for _, set := range splitFamilies(endpoints) {
+		fmt.Fprintf(&b, "\t\t%s saddr { %s } udp sport %d accept\n", set.family, set.elements, endpointPort)
+	}
+	if allowLAN {
+		fmt.Fprintf(&b, "\t\tip saddr { %s } accept\n", strings.Join(lanRangesV4, ", "))
+		fmt.Fprintf(&b, "\t\tip6 saddr { %s } accept\n", strings.Join(lanRangesV6, ", "))
+	}
+	b.WriteString("\t}\n")
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// addressSet is the elements of an nftables set of one address family.
+type addressSet struct {
+	family   string
+	elements string
+}
+
+// splitFamilies groups ips into sets per address family, IPv4 first and
+// without duplicates, which nft refuses.
+func splitFamilies(ips []net.IP) []addressSet {
+	v4, v6 := []string{}, []string{}
+	seen := map[string]bool{}
+	for _, ip := range ips {
+		if seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		if ip.To4() != nil {
+			v4 = append(v4, ip.String())
+		} else {
+			v6 = append(v6, ip.String())
+		}
+	}
+	sets := []addressSet{}
+	if len(v4) > 0 {
+		sets = append(sets, addressSet{family: "ip", elements: strings.Join(v4, ", ")})
+	}
+	if len(v6) > 0 {
+		sets = append(sets, addressSet{family: "ip6", elements: strings.Join(v6, ", ")})
+	}
+	return sets
+}
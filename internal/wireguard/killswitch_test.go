@@ -0,0 +1,112 @@
+package wireguard
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/leetsecure/qryptic-client-cli/internal/models"
+)
+
+func TestRenderKillSwitchRulesControlPlane(t *testing.T) {
+	control := controlPlane{
+		controller:     []net.IP{net.ParseIP("203.0.113.10"), net.ParseIP("2001:db8::10")},
+		controllerPort: 8443,
+		resolvers:      []net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.1"), net.ParseIP("1.1.1.1")},
+	}
+	rules := renderKillSwitchRules("wg0", []net.IP{net.ParseIP("198.51.100.7")}, 51820, control, false)
+
+	for _, want := range []string{
+		"ip daddr { 198.51.100.7 } udp dport 51820 accept",
+		"ip daddr { 203.0.113.10 } tcp dport 8443 accept",
+		"ip6 daddr { 2001:db8::10 } tcp dport 8443 accept",
+		"ip daddr { 192.168.1.1, 1.1.1.1 } meta l4proto { tcp, udp } th dport 53 accept",
+		"ct state established,related accept",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules lack %q:\n%s", want, rules)
+		}
+	}
+	if strings.Contains(rules, "192.168.0.0/16") {
+		t.Error("LAN allowed without allowLAN")
+	}
+}
+
+func TestRenderKillSwitchRulesWithoutControlPlane(t *testing.T) {
+	rules := renderKillSwitchRules("wg0", []net.IP{net.ParseIP("2001:db8::7")}, 51820, controlPlane{}, true)
+	if !strings.Contains(rules, "ip6 daddr { 2001:db8::7 } udp dport 51820 accept") {
+		t.Errorf("IPv6 endpoint not allowed:\n%s", rules)
+	}
+	// nft refuses empty sets
+	if strings.Contains(rules, "{  }") {
+		t.Errorf("rules contain an empty set:\n%s", rules)
+	}
+	if !strings.Contains(rules, "192.168.0.0/16") {
+		t.Error("LAN not allowed with allowLAN")
+	}
+}
+
+func TestRenderKillSwitchRulesDomainEndpoint(t *testing.T) {
+	lookups := []string{}
+	lookupIP = func(host string) ([]net.IP, error) {
+		lookups = append(lookups, host)
+		return []net.IP{net.ParseIP("198.51.100.7"), net.ParseIP("198.51.100.8"), net.ParseIP("2001:db8::7")}, nil
+	}
+	t.Cleanup(func() { lookupIP = net.LookupIP })
+
+	endpoints, err := resolveEndpoint(models.WGClientPeerConfig{VpnGatewayDomain: "gw.example.com", VpnGatewayPort: 51820})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lookups) != 1 || lookups[0] != "gw.example.com" {
+		t.Errorf("looked up %v", lookups)
+	}
+	rules := renderKillSwitchRules("wg0", endpoints, 51820, controlPlane{}, false)
+	for _, want := range []string{
+		"ip daddr { 198.51.100.7, 198.51.100.8 } udp dport 51820 accept",
+		"ip6 daddr { 2001:db8::7 } udp dport 51820 accept",
+		"ip saddr { 198.51.100.7, 198.51.100.8 } udp sport 51820 accept",
+		"ip6 saddr { 2001:db8::7 } udp sport 51820 accept",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules lack %q:\n%s", want, rules)
+		}
+	}
+}
+
+func TestResolveEndpointErrors(t *testing.T) {
+	lookupIP = func(host string) ([]net.IP, error) { return nil, nil }
+	t.Cleanup(func() { lookupIP = net.LookupIP })
+
+	for _, peer := range []models.WGClientPeerConfig{
+		{},
+		{VpnGatewayIP: "not-an-ip"},
+		{VpnGatewayDomain: "gw.example.com"},
+	} {
+		if endpoints, err := resolveEndpoint(peer); err == nil {
+			t.Errorf("%+v: endpoints %v, want an error", peer, endpoints)
+		}
+	}
+}
+
+func TestResolveControlPlanePort(t *testing.T) {
+	for baseUrl, want := range map[string]int{
+		"https://127.0.0.1":      443,
+		"http://127.0.0.1":       80,
+		"https://127.0.0.1:8443": 8443,
+	} {
+		control, err := resolveControlPlane(baseUrl)
+		if err != nil {
+			t.Fatalf("%s: %v", baseUrl, err)
+		}
+		if control.controllerPort != want {
+			t.Errorf("%s: port %d, want %d", baseUrl, control.controllerPort, want)
+		}
+		if len(control.controller) != 1 || !control.controller[0].Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("%s: addresses %v", baseUrl, control.controller)
+		}
+	}
+	if _, err := resolveControlPlane("not a url"); err == nil {
+		t.Error("invalid URL accepted")
+	}
+}
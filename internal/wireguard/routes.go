@@ -0,0 +1,63 @@
+package wireguard
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// excludeRoutes removes the excluded networks from allowedIPs, splitting
+// the allowed networks around them since AllowedIPs cannot express holes.
+// Entries that are not networks are kept as they are.
+func excludeRoutes(allowedIPs, excluded []string) []string {
+	if len(excluded) == 0 {
+		return allowedIPs
+	}
+	exclude := []netip.Prefix{}
+	for _, route := range excluded {
+		if prefix, err := netip.ParsePrefix(strings.TrimSpace(route)); err == nil {
+			exclude = append(exclude, prefix.Masked())
+		}
+	}
+	result := []string{}
+	for _, allowedIP := range allowedIPs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(allowedIP))
+		if err != nil {
+			result = append(result, allowedIP)
+			continue
+		}
+		remaining := []netip.Prefix{prefix.Masked()}
+		for _, ex := range exclude {
+			next := []netip.Prefix{}
+			for _, p := range remaining {
+				next = append(next, subtractPrefix(p, ex)...)
+			}
+			remaining = next
+		}
+		for _, p := range remaining {
+			result = append(result, p.String())
+		}
+	}
+	return result
+}
+
+// subtractPrefix returns the networks covering p without ex.
+func subtractPrefix(p, ex netip.Prefix) []netip.Prefix {
+	if p.Addr().Is4() != ex.Addr().Is4() || !p.Overlaps(ex) {
+		return []netip.Prefix{p}
+	}
+	if ex.Bits() <= p.Bits() {
+		return nil
+	}
+	low, high := splitPrefix(p)
+	return append(subtractPrefix(low, ex), subtractPrefix(high, ex)...)
+}
+
+// splitPrefix halves p into two networks one bit longer.
+func splitPrefix(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := p.Bits()
+	addr := p.Addr().AsSlice()
+	low := netip.PrefixFrom(p.Addr(), bits+1)
+	addr[bits/8] |= 0x80 >> (bits % 8)
+	highAddr, _ := netip.AddrFromSlice(addr)
+	return low, netip.PrefixFrom(highAddr, bits+1)
+}
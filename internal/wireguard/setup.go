@@ -8,15 +8,14 @@ import (
 	"strings"
 
 	"github.com/leetsecure/qryptic-client-cli/internal/config"
-	"github.com/spf13/viper"
 )
 
 // Supported package managers for Linux
 var linuxPackageManagers = []string{"apt-get", "yum", "dnf", "zypper", "pacman"}
 
 // SetupWireGuard ensures tools and directories are properly set up.
-func SetupWireGuard() error {
-	isSetupCompleted := viper.GetViper().GetBool(config.IsWireguardSetupCompleted)
+func SetupWireGuard(store config.Store) error {
+	isSetupCompleted := store.GetWireguardSetup()
 	if isSetupCompleted {
 		fmt.Println("Setup already completed")
 		return nil
@@ -34,9 +33,7 @@ func SetupWireGuard() error {
 	}
 
 	fmt.Println("WireGuard setup completed successfully.")
-	viper.GetViper().Set(config.IsWireguardSetupCompleted, true)
-	viper.GetViper().WriteConfig()
-	return nil
+	return store.SetWireguardSetup(true)
 }
 
 // checkAndInstallTools ensures the required tools are installed.
@@ -1,33 +1,34 @@
 package wireguard
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"text/template"
+	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/leetsecure/qryptic-client-cli/internal/models"
+	"github.com/leetsecure/qryptic-client-cli/internal/wireguard/conf"
 )
 
-const wgConfigTemplate = `[Interface]
-PrivateKey = {{.InterfaceConfig.ClientPrivateKey}}
-Address = {{.InterfaceConfig.AllowedIpAddress}}
-DNS = {{.InterfaceConfig.DnsServer}}
-
-[Peer]
-PublicKey = {{.PeerConfig.ServerPublicKey}}
-AllowedIPs = {{range $index, $ip := .PeerConfig.AllowedIPs}}{{if $index}},{{end}}{{$ip}}{{end}}
-Endpoint = {{.PeerConfig.VpnGatewayIP}}:{{.PeerConfig.VpnGatewayPort}}
-PersistentKeepalive = {{.PeerConfig.PersistantAlive}}
-`
+// generatedConfigComment marks the configs written by Qryptic so that they
+// can be told apart from ones the user manages.
+const generatedConfigComment = "Generated by Qryptic, do not edit"
+const generatedConfigHeader = "# " + generatedConfigComment
 
 // WireGuardManager manages WireGuard configurations and connections.
 type WireGuardManager struct {
 	ConfigDir  string
 	ConfigPath string
 	Interface  string
+	// SplitDNS leaves the system resolver and routing table alone so that
+	// routes can be installed per resolved address by the DNS stub.
+	SplitDNS bool
+	// ExcludedRoutes are networks kept out of the tunnel.
+	ExcludedRoutes []string
+	DNS            *DNSManager
 }
 
 // NewWireGuardManager initializes a new WireGuardManager.
@@ -36,6 +37,7 @@ func NewWireGuardManager(configDir, interfaceName string) *WireGuardManager {
 		ConfigDir:  configDir,
 		ConfigPath: filepath.Join(configDir, "wg0.conf"),
 		Interface:  interfaceName,
+		DNS:        NewDNSManager(configDir),
 	}
 }
 
@@ -56,24 +58,30 @@ func (wg *WireGuardManager) ApplyConfig(clientConfig models.WGClientConfig) erro
 		return fmt.Errorf("failed to start VPN: %w", err)
 	}
 
+	// Point the resolver at the gateway DNS, unless the split DNS stub handles it
+	if !wg.SplitDNS {
+		servers := parseDNSServers(clientConfig.WGClientInterfaceConfig.DnsServer)
+		if err := wg.DNS.Apply(wg.TunnelInterface(), servers, clientConfig.WGClientInterfaceConfig.DnsDomains); err != nil {
+			wg.StopVPN()
+			return err
+		}
+	}
+
 	return nil
 }
 
-// generateConfig generates the WireGuard configuration file from the template.
+// generateConfig writes the WireGuard configuration file of clientConfig.
 func (wg *WireGuardManager) generateConfig(clientConfig models.WGClientConfig) error {
-	tmpl, err := template.New("wgConfig").Parse(wgConfigTemplate)
-	if err != nil {
-		return fmt.Errorf("failed to parse template: %w", err)
-	}
-
-	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, map[string]interface{}{
-		"InterfaceConfig": clientConfig.WGClientInterfaceConfig,
-		"PeerConfig":      clientConfig.WGClientPeerConfig,
+	wgConfig := conf.FromClientConfig(clientConfig, conf.Options{
+		Comment:    generatedConfigComment,
+		AllowedIPs: excludeRoutes(effectiveAllowedIPs(clientConfig), wg.ExcludedRoutes),
+		// The split DNS stub installs routes per resolved address
+		TableOff: wg.SplitDNS,
+		OmitDNS:  wg.SplitDNS || wg.DNS.Managed(),
 	})
-	// fmt.Println(buf.String())
-	if err != nil {
-		return fmt.Errorf("failed to execute template: %w", err)
+	// Refuse what wg-quick would refuse before replacing a working config
+	if err := wgConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid WireGuard config for this gateway: %w", err)
 	}
 
 	// Ensure configuration directory exists
@@ -84,7 +92,7 @@ func (wg *WireGuardManager) generateConfig(clientConfig models.WGClientConfig) e
 	}
 
 	// Write configuration file
-	if err := os.WriteFile(wg.ConfigPath, buf.Bytes(), 0600); err != nil {
+	if err := os.WriteFile(wg.ConfigPath, wgConfig.Bytes(), 0600); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
@@ -93,6 +101,26 @@ func (wg *WireGuardManager) generateConfig(clientConfig models.WGClientConfig) e
 	return nil
 }
 
+// effectiveAllowedIPs returns the AllowedIPs to render. A full-tunnel gateway
+// without IPv6 also gets ::/0, so IPv6 traffic is captured by the tunnel and
+// dropped there instead of escaping through the physical interface.
+func effectiveAllowedIPs(clientConfig models.WGClientConfig) []string {
+	allowedIPs := clientConfig.WGClientPeerConfig.AllowedIPs
+	fullTunnelV4, fullTunnelV6 := false, false
+	for _, allowedIP := range allowedIPs {
+		switch strings.TrimSpace(allowedIP) {
+		case "0.0.0.0/0":
+			fullTunnelV4 = true
+		case "::/0":
+			fullTunnelV6 = true
+		}
+	}
+	if fullTunnelV4 && !fullTunnelV6 {
+		return append(append([]string{}, allowedIPs...), "::/0")
+	}
+	return allowedIPs
+}
+
 // StartVPN brings up the WireGuard interface using the configuration.
 func (wg *WireGuardManager) StartVPN() error {
 	cmd := exec.Command("wg-quick", "up", wg.ConfigPath)
@@ -106,6 +134,10 @@ func (wg *WireGuardManager) StartVPN() error {
 
 // StopVPN brings down the WireGuard interface.
 func (wg *WireGuardManager) StopVPN() error {
+	// Restore DNS first, this also recovers from a session that crashed
+	if err := wg.DNS.Restore(); err != nil {
+		return fmt.Errorf("failed to restore DNS: %w", err)
+	}
 	_, err := os.Stat(wg.ConfigPath)
 	if err != nil {
 		fmt.Println("Config not present")
@@ -136,15 +168,87 @@ func (wg *WireGuardManager) CheckStatus() (bool, string, error) {
 	return len(output) > 0, string(output), nil
 }
 
-// Cleanup removes the current configuration file.
-func (wg *WireGuardManager) Cleanup() error {
+// RoutingDisabled reports whether the active config leaves the routing table
+// alone (Table = off), as it does for split DNS.
+func (wg *WireGuardManager) RoutingDisabled() bool {
+	data, err := os.ReadFile(wg.ConfigPath)
+	if err != nil {
+		return false
+	}
+	wgConfig, err := conf.Parse(data)
+	if err != nil || wgConfig.Interface() == nil {
+		return false
+	}
+	table, _ := wgConfig.Interface().Get(conf.KeyTable)
+	return table == "off"
+}
+
+// TunnelInterface returns the name of the interface created by wg-quick.
+// On macOS the utun device is allocated dynamically and recorded by wg-quick.
+func (wg *WireGuardManager) TunnelInterface() string {
+	name := strings.TrimSuffix(filepath.Base(wg.ConfigPath), filepath.Ext(wg.ConfigPath))
+	if runtime.GOOS == "darwin" {
+		data, err := os.ReadFile(filepath.Join("/var/run/wireguard", name+".name"))
+		if err == nil {
+			return strings.TrimSpace(string(data))
+		}
+		return wg.Interface
+	}
+	return name
+}
+
+// Cleanup stops the tunnel and removes every configuration file generated
+// by Qryptic, returning the paths that were removed. privateKeys are the
+// client keys of the cached gateways, they identify a config written before
+// generated configs carried a header.
+func (wg *WireGuardManager) Cleanup(privateKeys []string) ([]string, error) {
 	err := wg.StopVPN()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return removeGeneratedConfigs(wg.ConfigDir, wg.ConfigPath, privateKeys)
+}
 
-	if err := os.Remove(wg.ConfigPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove config file: %w", err)
+// removeGeneratedConfigs removes the configs in dir that carry the Qryptic
+// header, and legacyPath when its private key is one of privateKeys. Other
+// files are left to the user.
+func removeGeneratedConfigs(dir, legacyPath string, privateKeys []string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	removed := []string{}
+	for _, path := range paths {
+		if !isGeneratedConfig(path) && !(path == legacyPath && hasPrivateKey(path, privateKeys)) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove config file: %w", err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+func isGeneratedConfig(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(string(data), generatedConfigHeader)
+}
+
+// hasPrivateKey reports whether the [Interface] private key of the config at
+// path is one of privateKeys.
+func hasPrivateKey(path string, privateKeys []string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	wgConfig, err := conf.Parse(data)
+	if err != nil || wgConfig.Interface() == nil {
+		return false
+	}
+	privateKey, _ := wgConfig.Interface().Get(conf.KeyPrivateKey)
+	return privateKey != "" && slices.Contains(privateKeys, privateKey)
 }
@@ -0,0 +1,69 @@
+package wireguard
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRemoveGeneratedConfigs(t *testing.T) {
+	const storedKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	tests := []struct {
+		name    string
+		files   map[string]string
+		removed []string
+	}{
+		{
+			name: "generated configs",
+			files: map[string]string{
+				"qryptic.conf": generatedConfigHeader + "\n[Interface]\n",
+				"old.conf":     generatedConfigHeader + "\n[Interface]\n",
+				"wg0.conf":     "[Interface]\nPrivateKey = user-managed\n",
+				"notes.txt":    generatedConfigHeader + "\n",
+			},
+			removed: []string{"old.conf", "qryptic.conf"},
+		},
+		{
+			name: "legacy config with a stored key",
+			files: map[string]string{
+				"wg0.conf": "[Interface]\nPrivateKey = " + storedKey + "\nAddress = 10.8.0.2/32\n",
+			},
+			removed: []string{"wg0.conf"},
+		},
+		{
+			name: "other config with a stored key",
+			files: map[string]string{
+				"wg1.conf": "[Interface]\nPrivateKey = " + storedKey + "\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			removed, err := removeGeneratedConfigs(dir, filepath.Join(dir, "wg0.conf"), []string{storedKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{}
+			for _, name := range tt.removed {
+				want = append(want, filepath.Join(dir, name))
+			}
+			if !slices.Equal(removed, want) {
+				t.Errorf("removed %v, want %v", removed, want)
+			}
+			for name := range tt.files {
+				_, err := os.Stat(filepath.Join(dir, name))
+				if exists, kept := err == nil, !slices.Contains(tt.removed, name); exists != kept {
+					t.Errorf("%s: exists %v, want %v", name, exists, kept)
+				}
+			}
+		})
+	}
+}